@@ -0,0 +1,78 @@
+package bitmarklib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+const (
+	aes256KeySize = 32
+)
+
+// AES256GCMSessionKey is a session key for AES-256 in GCM mode, for
+// content which must be encrypted with a FIPS approved cipher. Every
+// ciphertext is framed with a random nonce like ChaCha20SessionKey.
+type AES256GCMSessionKey struct {
+	key []byte
+}
+
+func NewAES256GCMSessionKey() (*AES256GCMSessionKey, error) {
+	key := make([]byte, aes256KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	return &AES256GCMSessionKey{key: key}, nil
+}
+
+func (k *AES256GCMSessionKey) String() string {
+	return hex.EncodeToString(k.key)
+}
+
+func (k *AES256GCMSessionKey) Bytes() []byte {
+	return k.key
+}
+
+func (k *AES256GCMSessionKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt the plaintext using a random nonce
+func (k *AES256GCMSessionKey) Encrypt(plaintext []byte) ([]byte, error) {
+	return k.EncryptWithAdditionalData(plaintext, nil)
+}
+
+// Decrypt the ciphertext produced by Encrypt
+func (k *AES256GCMSessionKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	return k.DecryptWithAdditionalData(ciphertext, nil)
+}
+
+// EncryptWithAdditionalData is Encrypt, with the additional data
+// authenticated along with the frame version
+func (k *AES256GCMSessionKey) EncryptWithAdditionalData(plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	return sealFrame(aead, plaintext, additionalData)
+}
+
+// DecryptWithAdditionalData decrypts the ciphertext produced by
+// EncryptWithAdditionalData
+func (k *AES256GCMSessionKey) DecryptWithAdditionalData(ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	return openFrame(aead, ciphertext, additionalData)
+}
@@ -0,0 +1,79 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAES256GCMSessionKey(t *testing.T) {
+	sessKey, err := NewAES256GCMSessionKey()
+	assert.NoError(t, err)
+
+	message := []byte("Hello, world!")
+	c1, err := sessKey.Encrypt(message)
+	assert.NoError(t, err)
+	c2, err := sessKey.Encrypt(message)
+	assert.NoError(t, err)
+	assert.NotEqual(t, c1, c2)
+
+	plaintext, err := sessKey.Decrypt(c1)
+	assert.NoError(t, err)
+	assert.Equal(t, message, plaintext)
+
+	c1[len(c1)-1] ^= 0x01
+	_, err = sessKey.Decrypt(c1)
+	assert.Error(t, err)
+
+	key, err := SessionKeyFromHex(Aes256gcm, sessKey.String())
+	assert.NoError(t, err)
+	plaintext, err = key.Decrypt(c2)
+	assert.NoError(t, err)
+	assert.Equal(t, message, plaintext)
+
+	_, err = SessionKeyFromHex(Aes256gcm, "00")
+	assert.Error(t, err)
+}
+
+func TestAES256GCMAssetFile(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	_, rEncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	sessKey, err := NewAES256GCMSessionKey()
+	assert.NoError(t, err)
+
+	content := []byte("Hello, world!")
+	encrypted, err := EncryptAssetFile(content, sessKey, sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	context := AssetFileContext{BitmarkId: "bitmark", AssetId: "asset", Fingerprint: "fingerprint"}
+	encryptedWithContext, err := EncryptAssetFileWithContext(content, sessKey, sAuthKey.PrivateKeyBytes(), context)
+	assert.NoError(t, err)
+
+	var stream bytes.Buffer
+	err = EncryptAssetFileStream(&stream, bytes.NewReader(content), sessKey, sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	// the recipient learns the algorithm from the session data
+	data, err := CreateSessionData(sessKey, toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, Aes256gcm, data.Algorithm)
+
+	key, err := SessionKeyFromSessionData(data, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.IsType(t, &AES256GCMSessionKey{}, key)
+
+	plaintext, err := DecryptAssetFile(encrypted, key, sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, content, plaintext)
+
+	plaintext, err = DecryptAssetFileWithContext(encryptedWithContext, key, sAuthKey.PublicKeyBytes(), context)
+	assert.NoError(t, err)
+	assert.Equal(t, content, plaintext)
+
+	var decrypted bytes.Buffer
+	err = DecryptAssetFileStream(&decrypted, &stream, key, sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+}
@@ -0,0 +1,95 @@
+package bitmarklib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"golang.org/x/crypto/ed25519"
+)
+
+// EncryptionKeyAnnouncement publishes the encryption public key of an
+// account, signed by the account's auth key so that whoever fetches it
+// can tell it was not swapped on the way
+type EncryptionKeyAnnouncement struct {
+	Account    *account.Account
+	EncrPubKey []byte
+	Signature  []byte
+}
+
+// NewEncryptionKeyAnnouncement signs the encryption public key of an
+// account with its auth key
+func NewEncryptionKeyAnnouncement(keys *AccountKeys) *EncryptionKeyAnnouncement {
+	encrPubKey := keys.EncrKey.PublicKeyBytes()
+	return &EncryptionKeyAnnouncement{
+		Account:    keys.AuthKey.PublicKey(),
+		EncrPubKey: encrPubKey,
+		Signature:  keys.AuthKey.Sign(encrPubKey),
+	}
+}
+
+// Verify checks the signature of the account over the encryption public key
+func (a *EncryptionKeyAnnouncement) Verify() error {
+	if a.Account == nil {
+		return errors.New("missing account")
+	}
+	if len(a.EncrPubKey) != 32 {
+		return errors.New("invalid encryption public key size")
+	}
+
+	authPubkey := a.Account.PublicKeyBytes()
+	if len(authPubkey) != ed25519.PublicKeySize {
+		return errors.New("invalid auth public key size")
+	}
+	if !ed25519.Verify(authPubkey, a.EncrPubKey, a.Signature) {
+		return errors.New("invalid encryption public key signature")
+	}
+	return nil
+}
+
+func (a *EncryptionKeyAnnouncement) MarshalJSON() ([]byte, error) {
+	if a.Account == nil {
+		return nil, errors.New("missing account")
+	}
+
+	return json.Marshal(&struct {
+		Account    string `json:"account"`
+		EncrPubKey string `json:"encryption_pubkey"`
+		Signature  string `json:"signature"`
+	}{
+		Account:    a.Account.String(),
+		EncrPubKey: hex.EncodeToString(a.EncrPubKey),
+		Signature:  hex.EncodeToString(a.Signature),
+	})
+}
+
+func (a *EncryptionKeyAnnouncement) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Account    string `json:"account"`
+		EncrPubKey string `json:"encryption_pubkey"`
+		Signature  string `json:"signature"`
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	acc, err := account.AccountFromBase58(aux.Account)
+	if err != nil {
+		return err
+	}
+	encrPubKey, err := hex.DecodeString(aux.EncrPubKey)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(aux.Signature)
+	if err != nil {
+		return err
+	}
+
+	a.Account = acc
+	a.EncrPubKey = encrPubKey
+	a.Signature = signature
+	return nil
+}
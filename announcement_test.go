@@ -0,0 +1,38 @@
+package bitmarklib
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptionKeyAnnouncement(t *testing.T) {
+	seed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	keys, err := NewAccountKeys(seed)
+	assert.NoError(t, err)
+
+	a := NewEncryptionKeyAnnouncement(keys)
+	assert.Equal(t, keys.AuthKey.AccountNumber(), a.Account.String())
+	assert.Equal(t, keys.EncrKey.PublicKeyBytes(), a.EncrPubKey)
+	assert.NoError(t, a.Verify())
+
+	data, err := json.Marshal(a)
+	assert.NoError(t, err)
+
+	var decoded EncryptionKeyAnnouncement
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.NoError(t, decoded.Verify())
+	assert.Equal(t, a.EncrPubKey, decoded.EncrPubKey)
+
+	// an encryption key swapped by someone else
+	other, _ := SeedFromBase58("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	otherKeys, _ := NewAccountKeys(other)
+	decoded.EncrPubKey = otherKeys.EncrKey.PublicKeyBytes()
+	assert.Error(t, decoded.Verify())
+
+	// signed by a different account
+	forged := NewEncryptionKeyAnnouncement(otherKeys)
+	forged.Account = a.Account
+	assert.Error(t, forged.Verify())
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+// Headers of a signed request
+const (
+	HeaderRequester = "requester"
+	HeaderTimestamp = "timestamp"
+	HeaderNonce     = "nonce"
+	HeaderSignature = "signature"
+)
+
+// DefaultMaxSkew is how far the timestamp of a signed request may be
+// from the time of the server
+const DefaultMaxSkew = 5 * time.Minute
+
+const (
+	nonceSize    = 16
+	maxNonceSize = 64
+)
+
+var (
+	ErrMissingAuthHeaders = errors.New("missing request authentication headers")
+	ErrStaleRequest       = errors.New("request timestamp is out of range")
+	ErrInvalidSignature   = errors.New("invalid request signature")
+	ErrReplayedRequest    = errors.New("request nonce has already been used")
+)
+
+// canonicalRequest returns the signed form of a request:
+// method|path?query|requester|timestamp|nonce|hex of SHA3-256 of the body
+func canonicalRequest(method, requestURI, requester, timestamp, nonce string, body []byte) []byte {
+	digest := sha3.Sum256(body)
+	return []byte(strings.Join([]string{
+		method,
+		requestURI,
+		requester,
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "|"))
+}
+
+// SigningTransport signs every request with an AuthKey before passing it
+// on to the Base transport
+type SigningTransport struct {
+	Key  bitmarklib.AuthKey
+	Base http.RoundTripper
+
+	now func() time.Time
+}
+
+func NewSigningTransport(key bitmarklib.AuthKey, base http.RoundTripper) *SigningTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &SigningTransport{
+		Key:  key,
+		Base: base,
+		now:  time.Now,
+	}
+}
+
+func (t *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// a RoundTripper must not modify the request it is given
+	signed := req.Clone(req.Context())
+	if body != nil {
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	requester := t.Key.AccountNumber()
+	timestamp := strconv.FormatInt(t.now().UnixNano()/int64(time.Millisecond), 10)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	message := canonicalRequest(req.Method, req.URL.RequestURI(), requester, timestamp, hex.EncodeToString(nonce), body)
+
+	signed.Header.Set(HeaderRequester, requester)
+	signed.Header.Set(HeaderTimestamp, timestamp)
+	signed.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	signed.Header.Set(HeaderSignature, hex.EncodeToString(t.Key.Sign(message)))
+
+	return t.Base.RoundTrip(signed)
+}
+
+// readRequestBody returns the body of an outgoing request, leaving the
+// request readable again
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// SignRequests makes the client sign every request with the key
+func (c *Client) SignRequests(key bitmarklib.AuthKey) {
+	c.HTTPClient.Transport = NewSigningTransport(key, c.HTTPClient.Transport)
+}
+
+type requesterKey struct{}
+
+// RequesterFromContext returns the account number of the requester of a
+// request checked by VerifyRequests
+func RequesterFromContext(ctx context.Context) (string, bool) {
+	requester, ok := ctx.Value(requesterKey{}).(string)
+	return requester, ok
+}
+
+// Verifier checks the signature of requests signed by a SigningTransport.
+// It remembers the nonces of the requests it accepted while their
+// timestamps are in range, so a captured request can not be sent again.
+type Verifier struct {
+	MaxSkew time.Duration
+
+	// the largest request body that is read to check the signature
+	MaxBodySize int64
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[nonceKey]time.Time
+	lastPrune time.Time
+}
+
+type nonceKey struct {
+	requester string
+	nonce     string
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{
+		MaxSkew:     DefaultMaxSkew,
+		MaxBodySize: 10 * 1024 * 1024,
+		now:         time.Now,
+	}
+}
+
+// Verify checks the signature and freshness of a request, and that its
+// nonce was not seen before, and returns the account number of the
+// requester. The body is read and replaced, so the request can still be
+// handled afterwards.
+func (v *Verifier) Verify(req *http.Request) (string, error) {
+	requester := req.Header.Get(HeaderRequester)
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	signature, err := hex.DecodeString(req.Header.Get(HeaderSignature))
+	if requester == "" || timestamp == "" || nonce == "" || err != nil || len(signature) == 0 {
+		return "", ErrMissingAuthHeaders
+	}
+	if len(nonce) > maxNonceSize {
+		return "", ErrInvalidSignature
+	}
+
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrStaleRequest
+	}
+	signedAt := time.Unix(0, ms*int64(time.Millisecond))
+	skew := v.now().Sub(signedAt)
+	if skew > v.MaxSkew || skew < -v.MaxSkew {
+		return "", ErrStaleRequest
+	}
+
+	// only ed25519 accounts sign requests, and ed25519.Verify panics on
+	// a key of any other size
+	acc, err := account.AccountFromBase58(requester)
+	if err != nil || len(acc.PublicKeyBytes()) != ed25519.PublicKeySize {
+		return "", ErrInvalidSignature
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, v.MaxBodySize+1))
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if int64(len(body)) > v.MaxBodySize {
+			return "", errors.New("request body is too large")
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	message := canonicalRequest(req.Method, req.URL.RequestURI(), requester, timestamp, nonce, body)
+	if !ed25519.Verify(acc.PublicKeyBytes(), message, signature) {
+		return "", ErrInvalidSignature
+	}
+
+	if !v.useNonce(nonceKey{requester, nonce}, signedAt.Add(v.MaxSkew)) {
+		return "", ErrReplayedRequest
+	}
+	return requester, nil
+}
+
+// useNonce records a nonce until it expires, and reports whether it was
+// not already recorded
+func (v *Verifier) useNonce(key nonceKey, expires time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	if now.Sub(v.lastPrune) > v.MaxSkew {
+		for k, e := range v.nonces {
+			if now.After(e) {
+				delete(v.nonces, k)
+			}
+		}
+		v.lastPrune = now
+	}
+
+	if e, ok := v.nonces[key]; ok && !now.After(e) {
+		return false
+	}
+	if v.nonces == nil {
+		v.nonces = make(map[nonceKey]time.Time)
+	}
+	v.nonces[key] = expires
+	return true
+}
+
+// Middleware rejects requests that fail Verify with 401 Unauthorized,
+// and passes the requester of the others to next in the request context
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requester, err := v.Verify(r)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), requesterKey{}, requester)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
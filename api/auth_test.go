@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedRequests(t *testing.T) {
+	keys := mustAccountKeys(t)
+
+	var requester string
+	var body []byte
+	server := httptest.NewServer(NewVerifier().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requester, _ = RequesterFromContext(r.Context())
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"tx":{"id":"t1"}}`))
+	})))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, nil)
+	assert.NoError(t, err)
+
+	// unsigned requests are rejected
+	_, err = c.GetTx(context.Background(), "t1")
+	assert.True(t, IsUnauthorized(err))
+	assert.Equal(t, "bitmark api: 401 "+ErrMissingAuthHeaders.Error(), err.Error())
+
+	c.SignRequests(keys.AuthKey)
+	_, err = c.GetTx(context.Background(), "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, keys.AuthKey.AccountNumber(), requester)
+
+	// the body is still readable after it has been verified
+	announcement := bitmarklib.NewEncryptionKeyAnnouncement(keys)
+	assert.NoError(t, c.PublishEncryptionKey(context.Background(), announcement))
+	assert.Contains(t, string(body), "encryption_pubkey")
+}
+
+func TestVerifierRejects(t *testing.T) {
+	keys := mustAccountKeys(t)
+	now := time.Now()
+
+	sign := func(req *http.Request, at time.Time) *http.Request {
+		var signed *http.Request
+		transport := NewSigningTransport(keys.AuthKey, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			signed = r
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}))
+		transport.now = func() time.Time { return at }
+		transport.RoundTrip(req)
+		return signed
+	}
+	newRequest := func(body string) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, "http://api.bitmark.com/v1/transfer?x=1", bytes.NewBufferString(body))
+		return req
+	}
+
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	requester, err := v.Verify(sign(newRequest(`{"a":1}`), now))
+	assert.NoError(t, err)
+	assert.Equal(t, keys.AuthKey.AccountNumber(), requester)
+
+	_, err = v.Verify(sign(newRequest(`{"a":1}`), now.Add(-time.Hour)))
+	assert.Equal(t, ErrStaleRequest, err)
+
+	_, err = v.Verify(sign(newRequest(`{"a":1}`), now.Add(time.Hour)))
+	assert.Equal(t, ErrStaleRequest, err)
+
+	// the body was changed after signing
+	req := sign(newRequest(`{"a":1}`), now)
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"a":2}`))
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// the query was changed after signing
+	req = sign(newRequest(`{"a":1}`), now)
+	req.URL.RawQuery = "x=2"
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// claimed to be signed by another account
+	other, _ := bitmarklib.NewSeed(bitmarklib.SeedVersion1, bitmarklib.Testnet)
+	otherKeys, _ := bitmarklib.NewAccountKeys(other)
+	req = sign(newRequest(`{"a":1}`), now)
+	req.Header.Set(HeaderRequester, otherKeys.AuthKey.AccountNumber())
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	_, err = v.Verify(newRequest(`{"a":1}`))
+	assert.Equal(t, ErrMissingAuthHeaders, err)
+
+	req = sign(newRequest(`{"a":1}`), now)
+	req.Header.Del(HeaderNonce)
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrMissingAuthHeaders, err)
+
+	// the nonce was changed after signing
+	req = sign(newRequest(`{"a":1}`), now)
+	req.Header.Set(HeaderNonce, "00")
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// a requester that is not an ed25519 account is refused, not a panic
+	nothing := &account.Account{AccountInterface: &account.NothingAccount{Test: true, PublicKey: make([]byte, 2)}}
+	req = sign(newRequest(`{"a":1}`), now)
+	req.Header.Set(HeaderRequester, nothing.String())
+	_, err = v.Verify(req)
+	assert.Equal(t, ErrInvalidSignature, err)
+}
+
+func TestVerifierReplay(t *testing.T) {
+	keys := mustAccountKeys(t)
+	now := time.Now()
+
+	var signed *http.Request
+	transport := NewSigningTransport(keys.AuthKey, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		signed = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	transport.now = func() time.Time { return now }
+	sign := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, "http://api.bitmark.com/v1/transfer", bytes.NewBufferString(`{"a":1}`))
+		transport.RoundTrip(req)
+		return signed
+	}
+
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	req := sign()
+	replayed := req.Clone(context.Background())
+	_, err := v.Verify(req)
+	assert.NoError(t, err)
+
+	replayed.Body = ioutil.NopCloser(bytes.NewBufferString(`{"a":1}`))
+	_, err = v.Verify(replayed)
+	assert.Equal(t, ErrReplayedRequest, err)
+
+	// the same request signed again has a new nonce
+	_, err = v.Verify(sign())
+	assert.NoError(t, err)
+
+	// expired nonces are forgotten, as their requests are stale anyway
+	now = now.Add(2*DefaultMaxSkew + time.Second)
+	_, err = v.Verify(sign())
+	assert.NoError(t, err)
+	assert.Len(t, v.nonces, 1)
+}
+
+func TestSigningTransportKeepsRequest(t *testing.T) {
+	keys := mustAccountKeys(t)
+	transport := NewSigningTransport(keys.AuthKey, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	req, _ := http.NewRequest(http.MethodPut, "http://api.bitmark.com/v1/session/b1", bytes.NewBufferString("data"))
+	_, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Empty(t, req.Header.Get(HeaderSignature))
+
+	body, _ := req.GetBody()
+	b, _ := ioutil.ReadAll(body)
+	assert.Equal(t, "data", string(b))
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,424 @@
+// Package api is a client of the Bitmark API v1
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+)
+
+const (
+	LivenetURL = "https://api.bitmark.com"
+	TestnetURL = "https://api.test.bitmark.com"
+	DevelURL   = "https://api.devel.bitmark.com"
+
+	defaultTimeout = 30 * time.Second
+
+	// error responses longer than this are cut when read
+	maxErrorBodySize = 64 * 1024
+)
+
+// Status of a bitmark, an asset or a transaction
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusRejected  = "rejected"
+)
+
+// Client sends requests to the Bitmark API. Its HTTPClient can be
+// replaced, for example to add a Transport that signs the requests.
+// Requests are sent once, unless a Policy is set.
+type Client struct {
+	BaseURL    *url.URL
+	HTTPClient *http.Client
+	Policy     *Policy
+}
+
+// NewClient returns a client of the API at baseURL. If tlsConfig is nil,
+// the server certificate is verified against the system roots.
+func NewClient(baseURL string, tlsConfig *tls.Config) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.New("base url must be http or https")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Client{
+		BaseURL: u,
+		HTTPClient: &http.Client{
+			Transport: transport,
+			Timeout:   defaultTimeout,
+		},
+	}, nil
+}
+
+// Bitmark is a bitmark as returned by the API
+type Bitmark struct {
+	Id          string `json:"id"`
+	HeadId      string `json:"head_id"`
+	Owner       string `json:"owner"`
+	AssetId     string `json:"asset_id"`
+	Issuer      string `json:"issuer"`
+	Status      string `json:"status"`
+	BlockNumber uint64 `json:"block_number"`
+}
+
+// Asset is an asset as returned by the API
+type Asset struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	Fingerprint string            `json:"fingerprint"`
+	Metadata    map[string]string `json:"metadata"`
+	Registrant  string            `json:"registrant"`
+	Status      string            `json:"status"`
+	BlockNumber uint64            `json:"block_number"`
+}
+
+// Tx is a transaction of a bitmark as returned by the API
+type Tx struct {
+	Id          string `json:"id"`
+	BitmarkId   string `json:"bitmark_id"`
+	AssetId     string `json:"asset_id"`
+	Owner       string `json:"owner"`
+	PreviousId  string `json:"previous_id"`
+	Status      string `json:"status"`
+	BlockNumber uint64 `json:"block_number"`
+}
+
+type txResult struct {
+	TxId string `json:"txId"`
+}
+
+// Issue registers the assets and issues bitmarks of them, and returns
+// the txids of the issues
+func (c *Client) Issue(ctx context.Context, assets []bitmarklib.Asset, issues []bitmarklib.Issue) ([]string, error) {
+	body := struct {
+		Assets []bitmarklib.Asset `json:"assets"`
+		Issues []bitmarklib.Issue `json:"issues"`
+	}{assets, issues}
+
+	var results []txResult
+	accepted := func(ctx context.Context) bool {
+		found, ok := c.findTxs(ctx, issues...)
+		if ok {
+			results = found
+		}
+		return ok
+	}
+	if err := c.send(ctx, http.MethodPost, "/v1/issue", nil, body, &results, accepted); err != nil {
+		return nil, err
+	}
+
+	txIds := make([]string, len(results))
+	for i, r := range results {
+		txIds[i] = r.TxId
+	}
+	return txIds, nil
+}
+
+// Transfer submits a signed transfer and returns its txid
+func (c *Client) Transfer(ctx context.Context, transfer *bitmarklib.Transfer) (string, error) {
+	body := map[string]interface{}{
+		"transfer": transfer,
+	}
+
+	var results []txResult
+	accepted := func(ctx context.Context) bool {
+		txId, err := transfer.TxId()
+		if err != nil {
+			return false
+		}
+		if _, err := c.GetTx(ctx, txId); err != nil {
+			return false
+		}
+		results = []txResult{{txId}}
+		return true
+	}
+	if err := c.send(ctx, http.MethodPost, "/v1/transfer", nil, body, &results, accepted); err != nil {
+		return "", err
+	}
+	if len(results) != 1 {
+		return "", errors.New("unexpected transfer response")
+	}
+	return results[0].TxId, nil
+}
+
+// findTxs returns the txids of the issues if the API has all of them
+func (c *Client) findTxs(ctx context.Context, issues ...bitmarklib.Issue) ([]txResult, bool) {
+	if len(issues) == 0 {
+		return nil, false
+	}
+	results := make([]txResult, len(issues))
+	for n, issue := range issues {
+		txId, err := issue.TxId()
+		if err != nil {
+			return nil, false
+		}
+		if _, err := c.GetTx(ctx, txId); err != nil {
+			return nil, false
+		}
+		results[n].TxId = txId
+	}
+	return results, true
+}
+
+// PutSessionData stores the session data of a bitmark for the recipient.
+// The data is signed by the sender, who must own the bitmark.
+func (c *Client) PutSessionData(ctx context.Context, bitmarkId, recipient string, data *bitmarklib.SessionData, sender bitmarklib.AuthKey) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{
+		"data":      string(b),
+		"signature": hex.EncodeToString(sender.Sign(b)),
+	}
+	query := url.Values{"account_no": {recipient}}
+	return c.do(ctx, http.MethodPut, "/v1/session/"+url.PathEscape(bitmarkId), query, body, nil)
+}
+
+// GetSessionData returns the session data of a bitmark for the account
+func (c *Client) GetSessionData(ctx context.Context, bitmarkId, accountNumber string) (*bitmarklib.SessionData, error) {
+	var data bitmarklib.SessionData
+	query := url.Values{"account_no": {accountNumber}}
+	if err := c.do(ctx, http.MethodGet, "/v1/session/"+url.PathEscape(bitmarkId), query, nil, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// PublishEncryptionKey publishes the signed encryption public key of an
+// account
+func (c *Client) PublishEncryptionKey(ctx context.Context, announcement *bitmarklib.EncryptionKeyAnnouncement) error {
+	if err := announcement.Verify(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/v1/encryption_keys/"+url.PathEscape(announcement.Account.String()), nil, announcement, nil)
+}
+
+// GetEncryptionKey returns the encryption public key of an account,
+// after checking that it is signed by the account
+func (c *Client) GetEncryptionKey(ctx context.Context, accountNumber string) (*bitmarklib.EncryptionKeyAnnouncement, error) {
+	acc, err := account.AccountFromBase58(accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		EncrPubKey string `json:"encryption_pubkey"`
+		Signature  string `json:"signature"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/encryption_keys/"+url.PathEscape(accountNumber), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	encrPubKey, err := hex.DecodeString(resp.EncrPubKey)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(resp.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	announcement := &bitmarklib.EncryptionKeyAnnouncement{
+		Account:    acc,
+		EncrPubKey: encrPubKey,
+		Signature:  signature,
+	}
+	if err := announcement.Verify(); err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// GetBitmark returns the bitmark of the id
+func (c *Client) GetBitmark(ctx context.Context, bitmarkId string) (*Bitmark, error) {
+	var resp struct {
+		Bitmark *Bitmark `json:"bitmark"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/bitmarks/"+url.PathEscape(bitmarkId), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Bitmark == nil {
+		return nil, errors.New("missing bitmark in response")
+	}
+	return resp.Bitmark, nil
+}
+
+// GetAsset returns the asset of the id
+func (c *Client) GetAsset(ctx context.Context, assetId string) (*Asset, error) {
+	var resp struct {
+		Asset *Asset `json:"asset"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/assets/"+url.PathEscape(assetId), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Asset == nil {
+		return nil, errors.New("missing asset in response")
+	}
+	return resp.Asset, nil
+}
+
+// GetTx returns the transaction of the txid
+func (c *Client) GetTx(ctx context.Context, txId string) (*Tx, error) {
+	var resp struct {
+		Tx *Tx `json:"tx"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/txs/"+url.PathEscape(txId), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Tx == nil {
+		return nil, errors.New("missing tx in response")
+	}
+	return resp.Tx, nil
+}
+
+// do sends a request with body encoded as JSON, and decodes a successful
+// response into result, or an error response into an *Error
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	return c.send(ctx, method, path, query, body, result, nil)
+}
+
+// send is do with the retries and limits of c.Policy. A request that may
+// have reached the API is only sent again if accepted, when given,
+// reports that the API does not have its records yet.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body, result interface{}, accepted func(context.Context) bool) error {
+	u := *c.BaseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	p := c.Policy
+	if p == nil {
+		_, _, err := c.attempt(ctx, method, u.String(), b, result)
+		return err
+	}
+
+	endpoint := endpointOf(method, path)
+	for n := 1; ; n++ {
+		// an open breaker fails the request before it takes a token
+		if err := p.allow(); err != nil {
+			return err
+		}
+		if err := p.wait(ctx, endpoint); err != nil {
+			p.record(false, false)
+			return err
+		}
+
+		delay, failed, err := c.attempt(ctx, method, u.String(), b, result)
+		p.record(failed, err == nil)
+		if err == nil || delay < 0 || n >= p.MaxAttempts {
+			return err
+		}
+
+		if backoff := p.backoff(n); backoff > delay {
+			delay = backoff
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		if accepted != nil && accepted(ctx) {
+			return nil
+		}
+	}
+}
+
+// attempt sends a request once. A failed request may be sent again after
+// delay, unless delay is negative. failed reports whether the network or
+// the API failed, rather than the request being refused.
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte, result interface{}) (delay time.Duration, failed bool, err error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return -1, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, false, err
+		}
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := decodeError(resp)
+		switch {
+		case resp.StatusCode >= 500:
+			return retryAfter(resp), true, err
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+			return retryAfter(resp), false, err
+		default:
+			return -1, false, err
+		}
+	}
+
+	if result == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return -1, false, err
+	}
+	return -1, false, json.NewDecoder(resp.Body).Decode(result)
+}
+
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return e
+	}
+
+	var body struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(b, &body) != nil {
+		e.Message = strings.TrimSpace(string(b))
+		return e
+	}
+
+	e.Code = body.Code
+	e.Message = body.Message
+	if e.Message == "" {
+		e.Message = body.Error
+	}
+	return e
+}
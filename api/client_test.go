@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+const testSeed = "5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV"
+
+func mustAccountKeys(t *testing.T) *bitmarklib.AccountKeys {
+	seed, err := bitmarklib.SeedFromBase58(testSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c, err := NewClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestIssue(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/issue", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var body struct {
+			Assets []json.RawMessage `json:"assets"`
+			Issues []json.RawMessage `json:"issues"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Len(t, body.Assets, 1)
+		assert.Len(t, body.Issues, 2)
+
+		fmt.Fprint(w, `[{"txId":"tx1"},{"txId":"tx2"}]`)
+	})
+
+	kp, _ := bitmarklib.NewKeyPair(true, bitmarklib.ED25519)
+	asset := bitmarklib.NewAsset("test", "fingerprint")
+	assert.NoError(t, asset.Sign(kp))
+	issues := make([]bitmarklib.Issue, 2)
+	for i := range issues {
+		issues[i] = bitmarklib.NewIssue(asset.AssetId())
+		assert.NoError(t, issues[i].Sign(kp))
+	}
+
+	txIds, err := c.Issue(context.Background(), []bitmarklib.Asset{asset}, issues)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tx1", "tx2"}, txIds)
+}
+
+func TestTransfer(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/transfer", r.URL.Path)
+
+		var body map[string]json.RawMessage
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Contains(t, body, "transfer")
+
+		fmt.Fprint(w, `[{"txId":"tx3"}]`)
+	})
+
+	keys := mustAccountKeys(t)
+	transfer, err := bitmarklib.NewTransfer("8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7", keys.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+	assert.NoError(t, transfer.ClaimedBy(keys.AuthKey))
+
+	txId, err := c.Transfer(context.Background(), transfer)
+	assert.NoError(t, err)
+	assert.Equal(t, "tx3", txId)
+}
+
+func TestSessionData(t *testing.T) {
+	keys := mustAccountKeys(t)
+	var stored []byte
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/session/bitmark1", r.URL.Path)
+		assert.Equal(t, "recipient1", r.URL.Query().Get("account_no"))
+
+		switch r.Method {
+		case http.MethodPut:
+			var body struct {
+				Data      string `json:"data"`
+				Signature string `json:"signature"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			signature, _ := hex.DecodeString(body.Signature)
+			assert.True(t, ed25519.Verify(keys.AuthKey.PublicKeyBytes(), []byte(body.Data), signature))
+			stored = []byte(body.Data)
+		case http.MethodGet:
+			w.Write(stored)
+		}
+	})
+
+	sessKey, _ := bitmarklib.NewChaCha20SessionKey()
+	recipient, _ := bitmarklib.NewSeed(bitmarklib.SeedVersion1, bitmarklib.Testnet)
+	recipientKeys, _ := bitmarklib.NewAccountKeys(recipient)
+
+	var recipientPubkey, senderPvtkey [32]byte
+	copy(recipientPubkey[:], recipientKeys.EncrKey.PublicKeyBytes())
+	copy(senderPvtkey[:], keys.EncrKey.PrivateKeyBytes())
+	data, err := bitmarklib.CreateSessionData(sessKey, &recipientPubkey, &senderPvtkey, keys.AuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, c.PutSessionData(ctx, "bitmark1", "recipient1", data, keys.AuthKey))
+
+	fetched, err := c.GetSessionData(ctx, "bitmark1", "recipient1")
+	assert.NoError(t, err)
+	assert.Equal(t, data, fetched)
+}
+
+func TestEncryptionKey(t *testing.T) {
+	keys := mustAccountKeys(t)
+	announcement := bitmarklib.NewEncryptionKeyAnnouncement(keys)
+	var stored []byte
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/encryption_keys/"+keys.AuthKey.AccountNumber(), r.URL.Path)
+
+		switch r.Method {
+		case http.MethodPost:
+			var body map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			stored, _ = json.Marshal(map[string]string{
+				"encryption_pubkey": body["encryption_pubkey"],
+				"signature":         body["signature"],
+			})
+		case http.MethodGet:
+			w.Write(stored)
+		}
+	})
+
+	ctx := context.Background()
+	assert.NoError(t, c.PublishEncryptionKey(ctx, announcement))
+
+	fetched, err := c.GetEncryptionKey(ctx, keys.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+	assert.Equal(t, announcement.EncrPubKey, fetched.EncrPubKey)
+
+	// a key swapped by the server is rejected
+	stored, _ = json.Marshal(map[string]string{
+		"encryption_pubkey": hex.EncodeToString(make([]byte, 32)),
+		"signature":         hex.EncodeToString(announcement.Signature),
+	})
+	_, err = c.GetEncryptionKey(ctx, keys.AuthKey.AccountNumber())
+	assert.Error(t, err)
+}
+
+func TestQueries(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/bitmarks/b1":
+			fmt.Fprint(w, `{"bitmark":{"id":"b1","head_id":"t2","owner":"o1","asset_id":"a1","status":"confirmed","block_number":12}}`)
+		case "/v1/assets/a1":
+			fmt.Fprint(w, `{"asset":{"id":"a1","name":"test","metadata":{"k":"v"},"status":"pending"}}`)
+		case "/v1/txs/t2":
+			fmt.Fprint(w, `{"tx":{"id":"t2","bitmark_id":"b1","previous_id":"t1","status":"confirmed"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code":1000,"message":"not found"}`)
+		}
+	})
+
+	ctx := context.Background()
+	bitmark, err := c.GetBitmark(ctx, "b1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Bitmark{Id: "b1", HeadId: "t2", Owner: "o1", AssetId: "a1", Status: StatusConfirmed, BlockNumber: 12}, bitmark)
+
+	asset, err := c.GetAsset(ctx, "a1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", asset.Metadata["k"])
+	assert.Equal(t, StatusPending, asset.Status)
+
+	tx, err := c.GetTx(ctx, "t2")
+	assert.NoError(t, err)
+	assert.Equal(t, "t1", tx.PreviousId)
+
+	_, err = c.GetTx(ctx, "missing")
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, &Error{StatusCode: http.StatusNotFound, Code: 1000, Message: "not found"}, err)
+}
+
+func TestErrorBody(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/txs/plain":
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":"invalid signature"}`)
+		}
+	})
+
+	_, err := c.GetTx(context.Background(), "plain")
+	assert.Equal(t, &Error{StatusCode: http.StatusBadGateway, Message: "bad gateway"}, err)
+
+	_, err = c.GetTx(context.Background(), "forbidden")
+	assert.True(t, IsUnauthorized(err))
+	assert.Equal(t, "bitmark api: 403 invalid signature", err.Error())
+}
+
+func TestContextCanceled(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.GetTx(ctx, "t1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tx":{"id":"t1"}}`)
+	}))
+	defer server.Close()
+
+	// the test certificate is not trusted by default
+	c, err := NewClient(server.URL, nil)
+	assert.NoError(t, err)
+	_, err = c.GetTx(context.Background(), "t1")
+	assert.Error(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	c, err = NewClient(server.URL, &tls.Config{RootCAs: roots})
+	assert.NoError(t, err)
+	tx, err := c.GetTx(context.Background(), "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "t1", tx.Id)
+}
+
+func TestNewClientInvalidURL(t *testing.T) {
+	_, err := NewClient("ftp://api.bitmark.com", nil)
+	assert.Error(t, err)
+}
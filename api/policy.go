@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts      = 5
+	DefaultMinBackoff       = 500 * time.Millisecond
+	DefaultMaxBackoff       = 30 * time.Second
+	DefaultRate             = 5
+	DefaultBurst            = 10
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+var (
+	ErrCircuitOpen = errors.New("bitmark api: too many failures, requests are paused")
+)
+
+// Limit is the rate of a token bucket, in requests per second, and the
+// number of requests it lets through at once. A zero Rate is no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Policy decides how a Client retries failed requests, how fast it
+// sends requests to each endpoint, and when it stops sending requests
+// because the API keeps failing. A Policy can be shared by clients of
+// the same API.
+//
+// Network errors, 5xx, 408 and 429 responses are retried after a
+// jittered backoff that doubles from MinBackoff up to MaxBackoff, or
+// after the Retry-After of the response if that is longer. Issues and
+// transfers are only sent again once their txids are not found. The
+// requests that look the txids up count against the limits and the
+// breaker like any other request.
+type Policy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	// Limit applies to each endpoint, such as "POST /v1/issue", unless
+	// Limits has one for it
+	Limit  Limit
+	Limits map[string]Limit
+
+	// after BreakerThreshold network errors or 5xx responses in a row,
+	// requests fail with ErrCircuitOpen for BreakerCooldown. Then a
+	// single request is let through, and the others fail until a 2xx
+	// response closes the breaker. A zero threshold disables the
+	// breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewPolicy() *Policy {
+	return &Policy{
+		MaxAttempts:      DefaultMaxAttempts,
+		MinBackoff:       DefaultMinBackoff,
+		MaxBackoff:       DefaultMaxBackoff,
+		Limit:            Limit{Rate: DefaultRate, Burst: DefaultBurst},
+		BreakerThreshold: DefaultBreakerThreshold,
+		BreakerCooldown:  DefaultBreakerCooldown,
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait takes a token from the bucket of the endpoint, waiting for one
+// if it is empty
+func (p *Policy) wait(ctx context.Context, endpoint string) error {
+	p.mu.Lock()
+	limit, ok := p.Limits[endpoint]
+	if !ok {
+		limit = p.Limit
+	}
+	if limit.Rate <= 0 {
+		p.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	b, ok := p.buckets[endpoint]
+	if !ok {
+		if p.buckets == nil {
+			p.buckets = make(map[string]*tokenBucket)
+		}
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		p.buckets[endpoint] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if max := float64(limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+
+	// the token is taken now, and the bucket goes below zero for the
+	// requests that wait
+	b.tokens--
+	delay := time.Duration(-b.tokens / limit.Rate * float64(time.Second))
+	p.mu.Unlock()
+
+	if err := sleep(ctx, delay); err != nil {
+		p.mu.Lock()
+		b.tokens++
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// allow returns ErrCircuitOpen while the breaker is open
+func (p *Policy) allow() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.BreakerThreshold <= 0 || p.failures < p.BreakerThreshold {
+		return nil
+	}
+	if p.probing || time.Now().Before(p.openUntil) {
+		return ErrCircuitOpen
+	}
+	p.probing = true
+	return nil
+}
+
+// record counts the failures in a row, and opens the breaker once they
+// reach the threshold. Only a success closes it: a request that was
+// refused or cancelled counts for nothing, and lets another one through.
+func (p *Policy) record(failed, succeeded bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probing = false
+	switch {
+	case succeeded:
+		p.failures = 0
+	case failed:
+		p.failures++
+		if p.BreakerThreshold > 0 && p.failures >= p.BreakerThreshold {
+			p.openUntil = time.Now().Add(p.BreakerCooldown)
+		}
+	}
+}
+
+// backoff returns the wait before sending a request again after attempt
+// failures, between half and all of the doubled backoff
+func (p *Policy) backoff(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if attempt < 32 && p.MinBackoff<<uint(attempt-1) < p.MaxBackoff {
+		d = p.MinBackoff << uint(attempt-1)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// endpointOf returns the method and the path up to the resource name,
+// which the rate limits are kept by
+func endpointOf(method, path string) string {
+	parts := strings.SplitN(path, "/", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return method + " " + strings.Join(parts, "/")
+}
+
+// retryAfter reads the Retry-After header of a response, in seconds or
+// as a date
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  4 * time.Millisecond,
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, `{"tx":{"id":"t1"}}`)
+		}
+	})
+	c.Policy = testPolicy()
+
+	tx, err := c.GetTx(context.Background(), "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "t1", tx.Id)
+	assert.Equal(t, int32(3), calls)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/v1/txs/bad" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	c.Policy = testPolicy()
+
+	_, err := c.GetTx(context.Background(), "t1")
+	assert.Equal(t, &Error{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"}, err)
+	assert.Equal(t, int32(3), calls)
+
+	// the request itself is refused, sending it again will not help
+	_, err = c.GetTx(context.Background(), "bad")
+	assert.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+	assert.Equal(t, int32(4), calls)
+
+	// the context ends the backoff
+	c.Policy.MinBackoff = time.Hour
+	c.Policy.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.GetTx(ctx, "t1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls, healthy int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"tx":{"id":"t1"}}`)
+	})
+	c.Policy = &Policy{
+		MaxAttempts:      1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetTx(ctx, "t1")
+		assert.IsType(t, &Error{}, err)
+	}
+	_, err := c.GetTx(ctx, "t1")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, int32(2), calls)
+
+	// a request let through after the cooldown that is cancelled does
+	// not close the breaker
+	time.Sleep(60 * time.Millisecond)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.GetTx(cancelled, "t1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, c.Policy.failures)
+
+	// a request that succeeds does
+	atomic.StoreInt32(&healthy, 1)
+	_, err = c.GetTx(ctx, "t1")
+	assert.NoError(t, err)
+	_, err = c.GetTx(ctx, "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), calls)
+}
+
+func TestCircuitBreakerRefused(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	c.Policy = &Policy{
+		MaxAttempts:      1,
+		Limit:            Limit{Rate: 1, Burst: 4},
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		c.GetTx(ctx, "t1")
+	}
+
+	// requests failed by the open breaker take no token
+	for i := 0; i < 5; i++ {
+		_, err := c.GetTx(ctx, "t1")
+		assert.Equal(t, ErrCircuitOpen, err)
+	}
+	assert.InDelta(t, 2, c.Policy.buckets["GET /v1/txs"].tokens, 0.5)
+
+	// a refused request does not close the breaker, but lets the next
+	// one through
+	time.Sleep(60 * time.Millisecond)
+	_, err := c.GetTx(ctx, "t1")
+	assert.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+	assert.Equal(t, 2, c.Policy.failures)
+	_, err = c.GetTx(ctx, "t1")
+	assert.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+	assert.Equal(t, int32(4), calls)
+}
+
+func TestRateLimit(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tx":{"id":"t1"},"asset":{"id":"a1"}}`)
+	})
+	c.Policy = &Policy{
+		MaxAttempts: 1,
+		Limit:       Limit{Rate: 20, Burst: 2},
+		Limits: map[string]Limit{
+			"GET /v1/assets": {},
+		},
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := c.GetTx(ctx, fmt.Sprint("t", i))
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+
+	// each endpoint has its own bucket
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		_, err := c.GetAsset(ctx, "a1")
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := c.GetTx(ctx, "t1")
+	assert.Equal(t, context.Canceled, err)
+}
+
+// lossyServer accepts records but fails the first reply, as a gateway
+// that times out after the API has the record
+func lossyServer(t *testing.T) (*Client, *int32) {
+	var posts int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+			http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+			return
+		}
+		if atomic.LoadInt32(&posts) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"tx":{"id":%q}}`, strings.TrimPrefix(r.URL.Path, "/v1/txs/"))
+	})
+	c.Policy = testPolicy()
+	return c, &posts
+}
+
+func TestRetryDedupe(t *testing.T) {
+	keys := mustAccountKeys(t)
+	ctx := context.Background()
+
+	c, posts := lossyServer(t)
+	transfer, _ := bitmarklib.NewTransfer("8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7", keys.AuthKey.AccountNumber())
+	assert.NoError(t, transfer.ClaimedBy(keys.AuthKey))
+	txId, err := c.Transfer(ctx, transfer)
+	assert.NoError(t, err)
+	expected, _ := transfer.TxId()
+	assert.Equal(t, expected, txId)
+	assert.Equal(t, int32(1), *posts)
+
+	c, posts = lossyServer(t)
+	asset := bitmarklib.NewAsset("test", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(keys.AuthKey))
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(keys.AuthKey))
+	txIds, err := c.Issue(ctx, []bitmarklib.Asset{asset}, []bitmarklib.Issue{issue})
+	assert.NoError(t, err)
+	expected, _ = issue.TxId()
+	assert.Equal(t, []string{expected}, txIds)
+	assert.Equal(t, int32(1), *posts)
+}
+
+func TestBackoff(t *testing.T) {
+	p := &Policy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := p.backoff(attempt + 1)
+			assert.True(t, d >= max/2 && d <= max, "attempt %d: %s", attempt+1, d)
+		}
+	}
+	assert.True(t, p.backoff(100) <= time.Second)
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, time.Duration(0), retryAfter(resp))
+
+	resp.Header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, retryAfter(resp))
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	d := retryAfter(resp)
+	assert.True(t, d > 58*time.Second && d <= time.Minute)
+
+	resp.Header.Set("Retry-After", "soon")
+	assert.Equal(t, time.Duration(0), retryAfter(resp))
+}
+
+func TestEndpointOf(t *testing.T) {
+	assert.Equal(t, "GET /v1/bitmarks", endpointOf(http.MethodGet, "/v1/bitmarks/abc"))
+	assert.Equal(t, "POST /v1/issue", endpointOf(http.MethodPost, "/v1/issue"))
+	assert.Equal(t, "PUT /v1/session", endpointOf(http.MethodPut, "/v1/session/abc/def"))
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultWatchMinInterval = time.Second
+	DefaultWatchMaxInterval = time.Minute
+	DefaultWatchRejectAfter = 10 * time.Minute
+)
+
+// TxGetter looks up transactions, as Client does
+type TxGetter interface {
+	GetTx(ctx context.Context, txId string) (*Tx, error)
+}
+
+// TxStatusChange is sent by a Watcher whenever the status of a
+// transaction changes
+type TxStatusChange struct {
+	TxId        string
+	Status      string
+	BlockNumber uint64
+}
+
+// Watcher polls the status of transactions until they are confirmed or
+// rejected. The polls back off from MinInterval to MaxInterval while no
+// status changes. A transaction that is not found for RejectAfter is
+// reported as rejected, as the node drops transactions it will not
+// confirm. Zero durations take their defaults.
+type Watcher struct {
+	Client      TxGetter
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	RejectAfter time.Duration
+}
+
+func NewWatcher(client TxGetter) *Watcher {
+	return &Watcher{
+		Client:      client,
+		MinInterval: DefaultWatchMinInterval,
+		MaxInterval: DefaultWatchMaxInterval,
+		RejectAfter: DefaultWatchRejectAfter,
+	}
+}
+
+type watchedTx struct {
+	status   string
+	lastSeen time.Time
+}
+
+// Watch sends the changes of status of the transactions on the returned
+// channel, which is closed once all of them are confirmed or rejected,
+// or when ctx is done. Errors other than a missing transaction are
+// taken as temporary, and the transaction is polled again.
+func (w *Watcher) Watch(ctx context.Context, txIds ...string) <-chan TxStatusChange {
+	changes := make(chan TxStatusChange)
+
+	go func() {
+		defer close(changes)
+
+		now := time.Now()
+		txs := make(map[string]*watchedTx, len(txIds))
+		for _, txId := range txIds {
+			txs[txId] = &watchedTx{lastSeen: now}
+		}
+
+		minInterval, maxInterval := w.intervals()
+		interval := minInterval
+		for len(txs) > 0 {
+			changed := false
+			for txId, watched := range txs {
+				change, ok := w.poll(ctx, txId, watched)
+				if !ok {
+					continue
+				}
+
+				changed = true
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+				if change.Status == StatusConfirmed || change.Status == StatusRejected {
+					delete(txs, txId)
+				}
+			}
+			if len(txs) == 0 {
+				return
+			}
+
+			if changed {
+				interval = minInterval
+			} else if interval *= 2; interval > maxInterval {
+				interval = maxInterval
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+// intervals returns the poll intervals, with the defaults for zero ones
+func (w *Watcher) intervals() (time.Duration, time.Duration) {
+	minInterval, maxInterval := w.MinInterval, w.MaxInterval
+	if minInterval <= 0 {
+		minInterval = DefaultWatchMinInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = DefaultWatchMaxInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	return minInterval, maxInterval
+}
+
+// poll returns the new status of a transaction, if it has changed
+func (w *Watcher) poll(ctx context.Context, txId string, watched *watchedTx) (TxStatusChange, bool) {
+	tx, err := w.Client.GetTx(ctx, txId)
+	switch {
+	case err == nil:
+		watched.lastSeen = time.Now()
+	case IsNotFound(err):
+		rejectAfter := w.RejectAfter
+		if rejectAfter <= 0 {
+			rejectAfter = DefaultWatchRejectAfter
+		}
+		if time.Since(watched.lastSeen) < rejectAfter {
+			return TxStatusChange{}, false
+		}
+		tx = &Tx{Id: txId, Status: StatusRejected}
+	default:
+		return TxStatusChange{}, false
+	}
+
+	if tx.Status == watched.status {
+		return TxStatusChange{}, false
+	}
+	watched.status = tx.Status
+	return TxStatusChange{
+		TxId:        txId,
+		Status:      tx.Status,
+		BlockNumber: tx.BlockNumber,
+	}, true
+}
+
+// ErrTxRejected is returned by Wait when a transaction is rejected
+type ErrTxRejected struct {
+	TxId string
+}
+
+func (e *ErrTxRejected) Error() string {
+	return "transaction rejected: " + e.TxId
+}
+
+// Wait blocks until all the transactions are confirmed. It fails as soon
+// as one is rejected, or when ctx is done.
+func (w *Watcher) Wait(ctx context.Context, txIds ...string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	confirmed := 0
+	for change := range w.Watch(ctx, txIds...) {
+		switch change.Status {
+		case StatusConfirmed:
+			confirmed++
+		case StatusRejected:
+			return &ErrTxRejected{change.TxId}
+		}
+	}
+	if confirmed < len(txIds) {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/go-bitmarklib/api"
+	"github.com/bitmark-inc/go-bitmarklib/bitmarktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWatcher(client api.TxGetter) *api.Watcher {
+	w := api.NewWatcher(client)
+	w.MinInterval = time.Millisecond
+	w.MaxInterval = 10 * time.Millisecond
+	w.RejectAfter = 50 * time.Millisecond
+	return w
+}
+
+func TestWatcher(t *testing.T) {
+	client := bitmarktest.NewFakeTxClient()
+	client.SetStatus("tx1", api.StatusPending, 0)
+	client.SetStatus("tx2", api.StatusPending, 0)
+
+	changes := newWatcher(client).Watch(context.Background(), "tx1", "tx2", "tx3")
+
+	seen := map[string][]string{}
+	for i := 0; i < 2; i++ {
+		c := <-changes
+		seen[c.TxId] = append(seen[c.TxId], c.Status)
+	}
+	assert.Equal(t, map[string][]string{"tx1": {api.StatusPending}, "tx2": {api.StatusPending}}, seen)
+
+	client.SetStatus("tx1", api.StatusConfirmed, 7)
+	c := <-changes
+	assert.Equal(t, api.TxStatusChange{TxId: "tx1", Status: api.StatusConfirmed, BlockNumber: 7}, c)
+
+	// tx2 is dropped by the node, and tx3 never shows up
+	client.Remove("tx2")
+	for c := range changes {
+		assert.Equal(t, api.StatusRejected, c.Status)
+		seen[c.TxId] = append(seen[c.TxId], c.Status)
+	}
+	assert.Equal(t, []string{api.StatusPending, api.StatusRejected}, seen["tx2"])
+	assert.Equal(t, []string{api.StatusRejected}, seen["tx3"])
+}
+
+func TestWatcherBackoff(t *testing.T) {
+	client := bitmarktest.NewFakeTxClient()
+	client.SetStatus("tx1", api.StatusPending, 0)
+
+	w := newWatcher(client)
+	w.MaxInterval = 40 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	for range w.Watch(ctx, "tx1") {
+	}
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+
+	// 1 + 2 + 4 + ... ms, then every 40ms: far fewer than one poll per ms
+	assert.True(t, client.Calls() < 15, "calls: %d", client.Calls())
+}
+
+func TestWatcherZero(t *testing.T) {
+	client := bitmarktest.NewFakeTxClient()
+	client.SetStatus("tx1", api.StatusPending, 0)
+
+	// a zero Watcher polls at the default intervals, and does not reject
+	// the missing tx2 at once
+	w := &api.Watcher{Client: client}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var changes []api.TxStatusChange
+	for c := range w.Watch(ctx, "tx1", "tx2") {
+		changes = append(changes, c)
+	}
+	assert.Equal(t, []api.TxStatusChange{{TxId: "tx1", Status: api.StatusPending}}, changes)
+	assert.Equal(t, 2, client.Calls())
+}
+
+func TestWatcherWait(t *testing.T) {
+	client := bitmarktest.NewFakeTxClient()
+	client.SetStatus("tx1", api.StatusPending, 0)
+	client.SetStatus("tx2", api.StatusConfirmed, 1)
+	w := newWatcher(client)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.SetStatus("tx1", api.StatusConfirmed, 2)
+	}()
+	assert.NoError(t, w.Wait(context.Background(), "tx1", "tx2"))
+
+	client.SetStatus("tx3", api.StatusRejected, 0)
+	err := w.Wait(context.Background(), "tx2", "tx3")
+	assert.Equal(t, &api.ErrTxRejected{TxId: "tx3"}, err)
+
+	client.SetStatus("tx4", api.StatusPending, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, w.Wait(ctx, "tx4"))
+}
+
+func TestWatcherMockServer(t *testing.T) {
+	s := bitmarktest.NewServer()
+	defer s.Close()
+
+	// an unknown txid on the mock server is rejected after RejectAfter
+	err := newWatcher(s.APIClient()).Wait(context.Background(), "unknown")
+	assert.Equal(t, &api.ErrTxRejected{TxId: "unknown"}, err)
+}
@@ -0,0 +1,461 @@
+// Package bitmarktest provides an in-process Bitmark API server for
+// integration tests. It keeps the ledger in memory and checks records
+// with the same code as bitmarkd, but has no network or blockchain.
+package bitmarktest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/bitmark-inc/go-bitmarklib/api"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Server is a mock of the issue, transfer, session data, encryption key
+// and query endpoints of the Bitmark API v1. New transactions are
+// pending until Confirm is called, unless AutoConfirm is set.
+type Server struct {
+	*httptest.Server
+
+	// AutoConfirm confirms every transaction in a block of its own as
+	// soon as it is accepted
+	AutoConfirm bool
+
+	mu             sync.Mutex
+	block          uint64
+	assets         map[string]*api.Asset
+	bitmarks       map[string]*api.Bitmark
+	txs            map[string]*api.Tx
+	owners         map[string]*account.Account
+	sessions       map[string]map[string][]byte
+	encryptionKeys map[string]*bitmarklib.EncryptionKeyAnnouncement
+}
+
+// NewServer starts a server, which must be closed by the caller
+func NewServer() *Server {
+	s := &Server{
+		assets:         make(map[string]*api.Asset),
+		bitmarks:       make(map[string]*api.Bitmark),
+		txs:            make(map[string]*api.Tx),
+		owners:         make(map[string]*account.Account),
+		sessions:       make(map[string]map[string][]byte),
+		encryptionKeys: make(map[string]*bitmarklib.EncryptionKeyAnnouncement),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/issue", s.handleIssue)
+	mux.HandleFunc("/v1/transfer", s.handleTransfer)
+	mux.HandleFunc("/v1/session/", s.handleSession)
+	mux.HandleFunc("/v1/encryption_keys/", s.handleEncryptionKey)
+	mux.HandleFunc("/v1/bitmarks/", s.handleBitmark)
+	mux.HandleFunc("/v1/assets/", s.handleAsset)
+	mux.HandleFunc("/v1/txs/", s.handleTx)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// APIClient returns a client of the server
+func (s *Server) APIClient() *api.Client {
+	c, err := api.NewClient(s.URL, nil)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Confirm puts all pending transactions in a new block and returns its
+// number. If nothing is pending, no block is made.
+func (s *Server) Confirm() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.confirm()
+}
+
+func (s *Server) confirm() uint64 {
+	pending := false
+	for _, tx := range s.txs {
+		if tx.Status == api.StatusPending {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return s.block
+	}
+
+	s.block++
+	for _, tx := range s.txs {
+		if tx.Status == api.StatusPending {
+			tx.Status = api.StatusConfirmed
+			tx.BlockNumber = s.block
+		}
+	}
+	for _, a := range s.assets {
+		if a.Status == api.StatusPending {
+			a.Status = api.StatusConfirmed
+			a.BlockNumber = s.block
+		}
+	}
+	for _, b := range s.bitmarks {
+		head := s.txs[b.HeadId]
+		b.Status = head.Status
+		b.BlockNumber = head.BlockNumber
+	}
+	return s.block
+}
+
+func (s *Server) accepted() {
+	if s.AutoConfirm {
+		s.confirm()
+	}
+}
+
+func (s *Server) handleIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	var body struct {
+		Assets []bitmarklib.Asset `json:"assets"`
+		Issues []bitmarklib.Issue `json:"issues"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// check everything before changing the ledger, so a bad request
+	// leaves no trace
+	newAssets := make(map[string]*api.Asset)
+	for _, a := range body.Assets {
+		if _, err := a.Pack(a.Registrant); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("asset: %s", err))
+			return
+		}
+		assetId := textOf(a.AssetId())
+		if _, ok := s.assets[assetId]; ok {
+			continue
+		}
+		newAssets[assetId] = &api.Asset{
+			Id:          assetId,
+			Name:        a.Name,
+			Fingerprint: a.Fingerprint,
+			Metadata:    splitMetadata(a.Metadata),
+			Registrant:  a.Registrant.String(),
+			Status:      api.StatusPending,
+		}
+	}
+
+	type issue struct {
+		txId    string
+		assetId string
+		owner   *account.Account
+	}
+	issues := make([]issue, 0, len(body.Issues))
+	for _, i := range body.Issues {
+		packed, err := i.Pack(i.Owner)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("issue: %s", err))
+			return
+		}
+		assetId := textOf(i.AssetId)
+		if s.assets[assetId] == nil && newAssets[assetId] == nil {
+			writeError(w, http.StatusBadRequest, errors.New("issue: asset not found"))
+			return
+		}
+		txId := textOf(packed.MakeLink())
+		if _, ok := s.txs[txId]; ok {
+			writeError(w, http.StatusBadRequest, errors.New("issue: duplicated transaction"))
+			return
+		}
+		issues = append(issues, issue{txId, assetId, i.Owner})
+	}
+
+	for id, a := range newAssets {
+		s.assets[id] = a
+	}
+
+	results := make([]map[string]string, len(issues))
+	for n, i := range issues {
+		s.txs[i.txId] = &api.Tx{
+			Id:        i.txId,
+			BitmarkId: i.txId,
+			AssetId:   i.assetId,
+			Owner:     i.owner.String(),
+			Status:    api.StatusPending,
+		}
+		s.bitmarks[i.txId] = &api.Bitmark{
+			Id:      i.txId,
+			HeadId:  i.txId,
+			Owner:   i.owner.String(),
+			AssetId: i.assetId,
+			Issuer:  i.owner.String(),
+			Status:  api.StatusPending,
+		}
+		s.owners[i.txId] = i.owner
+		results[n] = map[string]string{"txId": i.txId}
+	}
+	s.accepted()
+
+	writeJSON(w, results)
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	var body struct {
+		Transfer *bitmarklib.Transfer `json:"transfer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	t := body.Transfer
+	if t == nil || t.BitmarkTransferUnratified == nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing transfer"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link := textOf(t.Link)
+	previous, ok := s.txs[link]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("previous transaction not found"))
+		return
+	}
+	bitmark := s.bitmarks[previous.BitmarkId]
+	if bitmark.HeadId != link {
+		writeError(w, http.StatusBadRequest, errors.New("previous transaction is not the head of the bitmark"))
+		return
+	}
+
+	// the transfer is signed by the owner in the previous record
+	packed, err := t.Pack(s.owners[link])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("transfer: %s", err))
+		return
+	}
+
+	txId := textOf(packed.MakeLink())
+	s.txs[txId] = &api.Tx{
+		Id:         txId,
+		BitmarkId:  bitmark.Id,
+		AssetId:    bitmark.AssetId,
+		Owner:      t.Owner.String(),
+		PreviousId: link,
+		Status:     api.StatusPending,
+	}
+	s.owners[txId] = t.Owner
+	bitmark.HeadId = txId
+	bitmark.Owner = t.Owner.String()
+	bitmark.Status = api.StatusPending
+	s.accepted()
+
+	writeJSON(w, []map[string]string{{"txId": txId}})
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	bitmarkId := strings.TrimPrefix(r.URL.Path, "/v1/session/")
+	accountNumber := r.URL.Query().Get("account_no")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.bitmarks[bitmarkId]; !ok {
+		writeError(w, http.StatusNotFound, errors.New("bitmark not found"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := s.sessions[bitmarkId][accountNumber]
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("session data not found"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case http.MethodPut:
+		var body struct {
+			Data      string `json:"data"`
+			Signature string `json:"signature"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// only the owner of a bitmark can hand out access to its asset
+		signature, err := hex.DecodeString(body.Signature)
+		owner := s.owners[s.bitmarks[bitmarkId].HeadId]
+		if err != nil || !ed25519.Verify(owner.PublicKeyBytes(), []byte(body.Data), signature) {
+			writeError(w, http.StatusForbidden, errors.New("invalid signature"))
+			return
+		}
+
+		var data bitmarklib.SessionData
+		if err := json.Unmarshal([]byte(body.Data), &data); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if s.sessions[bitmarkId] == nil {
+			s.sessions[bitmarkId] = make(map[string][]byte)
+		}
+		s.sessions[bitmarkId][accountNumber] = []byte(body.Data)
+		writeJSON(w, map[string]string{"status": "ok"})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (s *Server) handleEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	accountNumber := strings.TrimPrefix(r.URL.Path, "/v1/encryption_keys/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		a, ok := s.encryptionKeys[accountNumber]
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("encryption key not found"))
+			return
+		}
+		writeJSON(w, map[string]string{
+			"encryption_pubkey": hex.EncodeToString(a.EncrPubKey),
+			"signature":         hex.EncodeToString(a.Signature),
+		})
+
+	case http.MethodPost:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// the account is taken from the path, as the body may not have it
+		var body struct {
+			EncrPubKey string `json:"encryption_pubkey"`
+			Signature  string `json:"signature"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		acc, err := account.AccountFromBase58(accountNumber)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		encrPubKey, err1 := hex.DecodeString(body.EncrPubKey)
+		signature, err2 := hex.DecodeString(body.Signature)
+		if err1 != nil || err2 != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid hex"))
+			return
+		}
+
+		a := &bitmarklib.EncryptionKeyAnnouncement{
+			Account:    acc,
+			EncrPubKey: encrPubKey,
+			Signature:  signature,
+		}
+		if err := a.Verify(); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		s.encryptionKeys[accountNumber] = a
+		writeJSON(w, map[string]string{"status": "ok"})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (s *Server) handleBitmark(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.bitmarks[strings.TrimPrefix(r.URL.Path, "/v1/bitmarks/")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("bitmark not found"))
+		return
+	}
+	writeJSON(w, map[string]*api.Bitmark{"bitmark": b})
+}
+
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.assets[strings.TrimPrefix(r.URL.Path, "/v1/assets/")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("asset not found"))
+		return
+	}
+	writeJSON(w, map[string]*api.Asset{"asset": a})
+}
+
+func (s *Server) handleTx(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, ok := s.txs[strings.TrimPrefix(r.URL.Path, "/v1/txs/")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("tx not found"))
+		return
+	}
+	writeJSON(w, map[string]*api.Tx{"tx": tx})
+}
+
+// textOf returns the text form of an id, as used in JSON and in the
+// transfer link
+func textOf(id interface{ MarshalText() ([]byte, error) }) string {
+	b, _ := id.MarshalText()
+	return string(b)
+}
+
+func splitMetadata(metadata string) map[string]string {
+	m := make(map[string]string)
+	if metadata == "" {
+		return m
+	}
+	parts := strings.Split(metadata, "\u0000")
+	for i := 0; i+1 < len(parts); i += 2 {
+		m[parts[i]] = parts[i+1]
+	}
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+}
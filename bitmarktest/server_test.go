@@ -0,0 +1,133 @@
+package bitmarktest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/bitmark-inc/go-bitmarklib/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAccount(t *testing.T) *bitmarklib.AccountKeys {
+	seed, err := bitmarklib.NewSeed(bitmarklib.SeedVersion1, bitmarklib.Testnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func toKey32(b []byte) *[32]byte {
+	var k [32]byte
+	copy(k[:], b)
+	return &k
+}
+
+func issue(t *testing.T, c *api.Client, owner *bitmarklib.AccountKeys) string {
+	asset := bitmarklib.NewAsset("test", "fingerprint")
+	assert.NoError(t, asset.SetMeta(map[string]string{"k": "v"}))
+	assert.NoError(t, asset.ClaimedBy(owner.AuthKey))
+	i := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, i.ClaimedBy(owner.AuthKey))
+
+	txIds, err := c.Issue(context.Background(), []bitmarklib.Asset{asset}, []bitmarklib.Issue{i})
+	if !assert.NoError(t, err) || !assert.Len(t, txIds, 1) {
+		t.FailNow()
+	}
+	return txIds[0]
+}
+
+func TestIssueAndTransfer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.APIClient()
+	ctx := context.Background()
+
+	alice := newAccount(t)
+	bob := newAccount(t)
+
+	bitmarkId := issue(t, c, alice)
+
+	bitmark, err := c.GetBitmark(ctx, bitmarkId)
+	assert.NoError(t, err)
+	assert.Equal(t, api.StatusPending, bitmark.Status)
+	assert.Equal(t, alice.AuthKey.AccountNumber(), bitmark.Owner)
+
+	asset, err := c.GetAsset(ctx, bitmark.AssetId)
+	assert.NoError(t, err)
+	assert.Equal(t, "v", asset.Metadata["k"])
+
+	assert.Equal(t, uint64(1), s.Confirm())
+	bitmark, _ = c.GetBitmark(ctx, bitmarkId)
+	assert.Equal(t, api.StatusConfirmed, bitmark.Status)
+	assert.Equal(t, uint64(1), bitmark.BlockNumber)
+
+	// only the owner can transfer
+	forged, _ := bitmarklib.NewTransfer(bitmarkId, bob.AuthKey.AccountNumber())
+	assert.NoError(t, forged.ClaimedBy(bob.AuthKey))
+	_, err = c.Transfer(ctx, forged)
+	assert.Error(t, err)
+
+	transfer, _ := bitmarklib.NewTransfer(bitmarkId, bob.AuthKey.AccountNumber())
+	assert.NoError(t, transfer.ClaimedBy(alice.AuthKey))
+	txId, err := c.Transfer(ctx, transfer)
+	assert.NoError(t, err)
+
+	tx, err := c.GetTx(ctx, txId)
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkId, tx.PreviousId)
+	assert.Equal(t, api.StatusPending, tx.Status)
+
+	bitmark, _ = c.GetBitmark(ctx, bitmarkId)
+	assert.Equal(t, bob.AuthKey.AccountNumber(), bitmark.Owner)
+	assert.Equal(t, txId, bitmark.HeadId)
+
+	// the old head can not be spent twice
+	_, err = c.Transfer(ctx, transfer)
+	assert.Error(t, err)
+
+	assert.Equal(t, uint64(2), s.Confirm())
+	assert.Equal(t, uint64(2), s.Confirm())
+}
+
+func TestSessionAndEncryptionKeys(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AutoConfirm = true
+	c := s.APIClient()
+	ctx := context.Background()
+
+	alice := newAccount(t)
+	bob := newAccount(t)
+	bitmarkId := issue(t, c, alice)
+
+	bitmark, _ := c.GetBitmark(ctx, bitmarkId)
+	assert.Equal(t, api.StatusConfirmed, bitmark.Status)
+
+	assert.NoError(t, c.PublishEncryptionKey(ctx, bitmarklib.NewEncryptionKeyAnnouncement(bob)))
+	bobKey, err := c.GetEncryptionKey(ctx, bob.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+
+	_, err = c.GetEncryptionKey(ctx, alice.AuthKey.AccountNumber())
+	assert.True(t, api.IsNotFound(err))
+
+	sessKey, _ := bitmarklib.NewChaCha20SessionKey()
+	data, err := bitmarklib.CreateSessionData(sessKey, toKey32(bobKey.EncrPubKey), toKey32(alice.EncrKey.PrivateKeyBytes()), alice.AuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	// bob does not own the bitmark, so can not store session data for it
+	err = c.PutSessionData(ctx, bitmarkId, bob.AuthKey.AccountNumber(), data, bob.AuthKey)
+	assert.True(t, api.IsUnauthorized(err))
+
+	assert.NoError(t, c.PutSessionData(ctx, bitmarkId, bob.AuthKey.AccountNumber(), data, alice.AuthKey))
+	fetched, err := c.GetSessionData(ctx, bitmarkId, bob.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+
+	key, err := bitmarklib.SessionKeyFromSessionData(fetched, toKey32(alice.EncrKey.PublicKeyBytes()), toKey32(bob.EncrKey.PrivateKeyBytes()), alice.AuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, sessKey.Bytes(), key.Bytes())
+}
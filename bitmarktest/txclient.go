@@ -0,0 +1,62 @@
+package bitmarktest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/bitmark-inc/go-bitmarklib/api"
+)
+
+// FakeTxClient is an api.TxGetter whose transactions are set by the test
+type FakeTxClient struct {
+	mu    sync.Mutex
+	txs   map[string]api.Tx
+	calls int
+}
+
+func NewFakeTxClient() *FakeTxClient {
+	return &FakeTxClient{
+		txs: make(map[string]api.Tx),
+	}
+}
+
+// SetStatus adds a transaction or changes its status
+func (c *FakeTxClient) SetStatus(txId, status string, blockNumber uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.txs[txId] = api.Tx{
+		Id:          txId,
+		Status:      status,
+		BlockNumber: blockNumber,
+	}
+}
+
+// Remove makes a transaction not found
+func (c *FakeTxClient) Remove(txId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.txs, txId)
+}
+
+// Calls returns the number of calls of GetTx
+func (c *FakeTxClient) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *FakeTxClient) GetTx(ctx context.Context, txId string) (*api.Tx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, ok := c.txs[txId]
+	if !ok {
+		return nil, &api.Error{StatusCode: http.StatusNotFound, Message: "tx not found"}
+	}
+	return &tx, nil
+}
@@ -0,0 +1,318 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// bundles are exported in upper case base32, which QR codes store
+	// in their compact alphanumeric mode
+	bundlePrefix         = "BMKBUNDLE1:"
+	bundleChecksumLength = 4
+
+	// bundles that decompress to more than this are refused
+	maxBundleSize = 1 << 20
+)
+
+var (
+	ErrBundleFormat   = errors.New("invalid bundle format")
+	ErrBundleChecksum = errors.New("bundle checksum mismatch")
+	ErrBundleEmpty    = errors.New("bundle has no records")
+	ErrBundleUnsigned = errors.New("record is not signed")
+	ErrBundleReview   = errors.New("bundle must be reviewed before it is signed")
+
+	bundleEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Bundle is a set of unsigned records, built on an online machine and
+// signed on an offline one that holds the Seed, in the spirit of a
+// Bitcoin PSBT. Each record names the account that must sign it: the
+// Registrant of an asset, the Owner of an issue and the Signer of a
+// transfer.
+type Bundle struct {
+	Assets    []Asset          `json:"assets,omitempty"`
+	Issues    []Issue          `json:"issues,omitempty"`
+	Transfers []BundleTransfer `json:"transfers,omitempty"`
+}
+
+// BundleTransfer is a transfer and the current owner of the bitmark,
+// who signs it
+type BundleTransfer struct {
+	Signer   *account.Account `json:"signer"`
+	Transfer *Transfer        `json:"transfer"`
+}
+
+// AddAsset adds an asset to be registered by the registrant
+func (b *Bundle) AddAsset(asset Asset, registrant *account.Account) {
+	asset.Registrant = registrant
+	asset.Signature = []byte{}
+	b.Assets = append(b.Assets, asset)
+}
+
+// AddIssues adds quantity issues of an asset to be owned by owner
+func (b *Bundle) AddIssues(assetId transactionrecord.AssetIdentifier, owner *account.Account, quantity int) {
+	for n := 0; n < quantity; n++ {
+		issue := NewIssue(assetId)
+		issue.Owner = owner
+		issue.Nonce = nextNonce()
+		b.Issues = append(b.Issues, issue)
+	}
+}
+
+// AddTransfer adds a transfer to be signed by the current owner of the
+// bitmark
+func (b *Bundle) AddTransfer(transfer *Transfer, owner *account.Account) {
+	transfer.Signature = []byte{}
+	b.Transfers = append(b.Transfers, BundleTransfer{Signer: owner, Transfer: transfer})
+}
+
+// Export returns the bundle as a string that can be written to a file
+// or shown as a QR code. The string is compressed and checksummed.
+func (b *Bundle) Export() (string, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	checksum := sha3.Sum256(buf.Bytes())
+	buf.Write(checksum[:bundleChecksumLength])
+	return bundlePrefix + bundleEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ImportBundle reads a bundle written by Export. Surrounding white space
+// and lower case, which QR scanners may add, are accepted.
+func ImportBundle(s string) (*Bundle, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	if !strings.HasPrefix(s, bundlePrefix) {
+		return nil, ErrBundleFormat
+	}
+
+	raw, err := bundleEncoding.DecodeString(strings.TrimPrefix(s, bundlePrefix))
+	if err != nil || len(raw) < bundleChecksumLength {
+		return nil, ErrBundleFormat
+	}
+
+	compressed := raw[:len(raw)-bundleChecksumLength]
+	checksum := sha3.Sum256(compressed)
+	if !bytes.Equal(checksum[:bundleChecksumLength], raw[len(compressed):]) {
+		return nil, ErrBundleChecksum
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxBundleSize+1))
+	if err != nil || len(data) > maxBundleSize {
+		return nil, ErrBundleFormat
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// BundleRecord describes a record of a bundle that SignBundle is about
+// to sign, so that it can be shown on the offline machine. Kind is
+// "asset", "issue" or "transfer", and Index is the position of the
+// record among those of its kind.
+type BundleRecord struct {
+	Kind        string
+	Index       int
+	Description string
+}
+
+// SignBundle signs the records of the bundle that are to be signed by
+// the account of the seed, and returns how many it signed. Records of
+// other accounts are left for their own signers. The records are first
+// given to review, which must show them to the user: nothing is signed
+// unless it returns nil, and its error is returned otherwise.
+func SignBundle(b *Bundle, seed *Seed, review func([]BundleRecord) error) (int, error) {
+	if review == nil {
+		return 0, ErrBundleReview
+	}
+
+	key, err := NewAuthKey(seed)
+	if err != nil {
+		return 0, err
+	}
+	signer := key.PublicKey()
+
+	records, err := bundleRecords(b, signer)
+	if err != nil {
+		return 0, err
+	}
+	if err := review(records); err != nil {
+		return 0, err
+	}
+
+	signed := 0
+	for n := range b.Assets {
+		a := &b.Assets[n]
+		if !sameAccount(a.Registrant, signer) {
+			continue
+		}
+		packed, err := a.Pack(a.Registrant)
+		if packed == nil {
+			return signed, fmt.Errorf("asset %d: %w", n, err)
+		}
+		a.Signature = key.Sign(packed)
+		signed++
+	}
+
+	for n := range b.Issues {
+		i := &b.Issues[n]
+		if !sameAccount(i.Owner, signer) {
+			continue
+		}
+		packed, err := i.Pack(i.Owner)
+		if packed == nil {
+			return signed, fmt.Errorf("issue %d: %w", n, err)
+		}
+		i.Signature = key.Sign(packed)
+		signed++
+	}
+
+	for n, t := range b.Transfers {
+		if t.Transfer == nil || !sameAccount(t.Signer, signer) {
+			continue
+		}
+		if err := t.Transfer.ClaimedBy(key); err != nil {
+			return signed, fmt.Errorf("transfer %d: %w", n, err)
+		}
+		signed++
+	}
+
+	return signed, nil
+}
+
+// bundleRecords describes the records of the bundle to be signed by
+// signer, after checking that each of them can be packed
+func bundleRecords(b *Bundle, signer *account.Account) ([]BundleRecord, error) {
+	var records []BundleRecord
+	for n := range b.Assets {
+		a := &b.Assets[n]
+		if !sameAccount(a.Registrant, signer) {
+			continue
+		}
+		if packed, err := a.Pack(a.Registrant); packed == nil {
+			return nil, fmt.Errorf("asset %d: %w", n, err)
+		}
+		records = append(records, BundleRecord{
+			Kind:        "asset",
+			Index:       n,
+			Description: fmt.Sprintf("register asset %q with fingerprint %q and metadata %q", a.Name, a.Fingerprint, a.Metadata),
+		})
+	}
+
+	for n := range b.Issues {
+		i := &b.Issues[n]
+		if !sameAccount(i.Owner, signer) {
+			continue
+		}
+		if packed, err := i.Pack(i.Owner); packed == nil {
+			return nil, fmt.Errorf("issue %d: %w", n, err)
+		}
+		records = append(records, BundleRecord{
+			Kind:        "issue",
+			Index:       n,
+			Description: fmt.Sprintf("issue a bitmark of asset %s", i.AssetId),
+		})
+	}
+
+	for n, t := range b.Transfers {
+		if t.Transfer == nil || !sameAccount(t.Signer, signer) {
+			continue
+		}
+		if _, err := t.Transfer.packUnsigned(); err != nil {
+			return nil, fmt.Errorf("transfer %d: %w", n, err)
+		}
+		link, _ := txIdText(t.Transfer.Link)
+		description := fmt.Sprintf("transfer the bitmark of transaction %s to %s", link, t.Transfer.Owner)
+		if e := t.Transfer.Escrow; e != nil {
+			description += fmt.Sprintf(", with an escrow payment of %d %s to %s", e.Amount, e.Currency, e.Address)
+		}
+		records = append(records, BundleRecord{
+			Kind:        "transfer",
+			Index:       n,
+			Description: description,
+		})
+	}
+
+	return records, nil
+}
+
+// Finalize checks that every record of the bundle is signed by its
+// account, after which the records can be submitted
+func Finalize(b *Bundle) error {
+	if len(b.Assets)+len(b.Issues)+len(b.Transfers) == 0 {
+		return ErrBundleEmpty
+	}
+
+	for n := range b.Assets {
+		a := &b.Assets[n]
+		if err := checkSigned(a.Signature, a.Registrant, func() error {
+			_, err := a.Pack(a.Registrant)
+			return err
+		}); err != nil {
+			return fmt.Errorf("asset %d: %w", n, err)
+		}
+	}
+
+	for n := range b.Issues {
+		i := &b.Issues[n]
+		if err := checkSigned(i.Signature, i.Owner, func() error {
+			_, err := i.Pack(i.Owner)
+			return err
+		}); err != nil {
+			return fmt.Errorf("issue %d: %w", n, err)
+		}
+	}
+
+	for n, t := range b.Transfers {
+		if t.Transfer == nil {
+			return fmt.Errorf("transfer %d: %w", n, ErrBundleFormat)
+		}
+		if err := checkSigned(t.Transfer.Signature, t.Signer, func() error {
+			_, err := t.Transfer.Pack(t.Signer)
+			return err
+		}); err != nil {
+			return fmt.Errorf("transfer %d: %w", n, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSigned returns ErrBundleUnsigned for a missing signature, or the
+// error of verify, which packs the record with its signer
+func checkSigned(signature []byte, signer *account.Account, verify func() error) error {
+	if signer == nil {
+		return ErrBundleFormat
+	}
+	if len(signature) == 0 {
+		return ErrBundleUnsigned
+	}
+	return verify()
+}
+
+func sameAccount(a, b *account.Account) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
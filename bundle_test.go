@@ -0,0 +1,133 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestBundle(t *testing.T) {
+	issuerSeed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	ownerSeed, _ := SeedFromBase58("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	issuer := mustAccountKeys(issuerSeed.String())
+	owner := mustAccountKeys(ownerSeed.String())
+
+	// built online, with public keys only
+	var b Bundle
+	asset := NewAsset("bundle", "fingerprint")
+	assert.NoError(t, asset.SetMeta(map[string]string{"author": "bitmark"}))
+	b.AddAsset(asset, issuer.AuthKey.PublicKey())
+	b.AddIssues(asset.AssetId(), issuer.AuthKey.PublicKey(), 2)
+	transfer, _ := NewTransfer("8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7", issuer.AuthKey.AccountNumber())
+	b.AddTransfer(transfer, owner.AuthKey.PublicKey())
+	assert.NotEqual(t, b.Issues[0].Nonce, b.Issues[1].Nonce)
+	assert.ErrorIs(t, Finalize(&b), ErrBundleUnsigned)
+
+	exported, err := b.Export()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9A-Z:]+$`), exported)
+
+	// signed offline, by each account in turn
+	imported, err := ImportBundle(exported)
+	assert.NoError(t, err)
+
+	// the records are shown before they are signed, and nothing is
+	// signed unless they are approved
+	var reviewed []BundleRecord
+	rejected := errors.New("rejected")
+	n, err := SignBundle(imported, issuerSeed, func(records []BundleRecord) error {
+		reviewed = records
+		return rejected
+	})
+	assert.Equal(t, rejected, err)
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, Finalize(imported), ErrBundleUnsigned)
+	assert.Empty(t, imported.Assets[0].Signature)
+	if assert.Len(t, reviewed, 3) {
+		assert.Equal(t, BundleRecord{"asset", 0, `register asset "bundle" with fingerprint "fingerprint" and metadata "author\x00bitmark"`}, reviewed[0])
+		assert.Equal(t, "issue", reviewed[1].Kind)
+		assert.Contains(t, reviewed[2].Description, asset.AssetId().String())
+		assert.Equal(t, 1, reviewed[2].Index)
+	}
+	_, err = SignBundle(imported, issuerSeed, nil)
+	assert.Equal(t, ErrBundleReview, err)
+
+	n, err = SignBundle(imported, issuerSeed, approve)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.EqualError(t, Finalize(imported), "transfer 0: record is not signed")
+
+	exported, _ = imported.Export()
+	imported, err = ImportBundle(" " + strings.ToLower(exported) + "\n")
+	assert.NoError(t, err)
+	n, err = SignBundle(imported, ownerSeed, func(records []BundleRecord) error {
+		if assert.Len(t, records, 1) {
+			assert.Equal(t, "transfer the bitmark of transaction 8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7 to "+issuer.AuthKey.AccountNumber(), records[0].Description)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// finalized online
+	assert.NoError(t, Finalize(imported))
+	assert.Equal(t, asset.AssetId(), imported.Assets[0].AssetId())
+	assert.Equal(t, b.Issues[1].Nonce, imported.Issues[1].Nonce)
+
+	txId, err := imported.Transfers[0].Transfer.TxId()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, txId)
+
+	// a record changed after it was signed is refused
+	imported.Issues[1].Nonce++
+	assert.Error(t, Finalize(imported))
+	imported.Issues[1].Nonce--
+	imported.Transfers[0].Signer = issuer.AuthKey.PublicKey()
+	assert.Error(t, Finalize(imported))
+
+	assert.Equal(t, ErrBundleEmpty, Finalize(&Bundle{}))
+}
+
+func approve([]BundleRecord) error {
+	return nil
+}
+
+func TestImportBundleErrors(t *testing.T) {
+	var b Bundle
+	asset := NewAsset("bundle", "fingerprint")
+	b.AddIssues(asset.AssetId(), mustAccountKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV").AuthKey.PublicKey(), 1)
+	exported, _ := b.Export()
+
+	_, err := ImportBundle(strings.TrimPrefix(exported, bundlePrefix))
+	assert.Equal(t, ErrBundleFormat, err)
+
+	_, err = ImportBundle(exported + "1")
+	assert.Equal(t, ErrBundleFormat, err)
+
+	// one character changed, as a misread QR code may have
+	corrupted := []byte(exported)
+	last := len(corrupted) - 10
+	if corrupted[last] == 'A' {
+		corrupted[last] = 'B'
+	} else {
+		corrupted[last] = 'A'
+	}
+	_, err = ImportBundle(string(corrupted))
+	assert.Equal(t, ErrBundleChecksum, err)
+
+	// a small bundle that decompresses to too much
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(bytes.Repeat([]byte(" "), maxBundleSize+1))
+	w.Close()
+	checksum := sha3.Sum256(buf.Bytes())
+	buf.Write(checksum[:bundleChecksumLength])
+	_, err = ImportBundle(bundlePrefix + bundleEncoding.EncodeToString(buf.Bytes()))
+	assert.Equal(t, ErrBundleFormat, err)
+}
@@ -0,0 +1,337 @@
+package bitmarklib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+const (
+	// AssetFileVersion1 is the first version of the asset file container
+	AssetFileVersion1 = 1
+
+	// AssetFileLegacy is the version reported for a headerless asset
+	// file made by EncryptAssetFile
+	AssetFileLegacy = 0
+
+	maxAssetFileChunkSize   = 16 * 1024 * 1024
+	maxAssetFileFieldLength = 64 * 1024
+)
+
+var (
+	assetFileMagic = []byte{'B', 'M', 'A', 'F'}
+)
+
+var (
+	ErrAssetFileVersion   = errors.New("unsupported asset file version")
+	ErrAssetFileHeader    = errors.New("invalid asset file header")
+	ErrAssetFileAlgorithm = errors.New("session key does not match the asset file algorithm")
+	ErrAssetFileSigner    = errors.New("asset file is not signed by the expected account")
+)
+
+// AssetFileHeader describes an encrypted asset file, so that it can be
+// identified and decrypted without outside context. SignMode is not
+// packed with the other fields, but read from the header of the
+// encrypted stream that follows them.
+type AssetFileHeader struct {
+	Version     int
+	Algorithm   int
+	ChunkSize   int
+	Signer      *account.Account
+	SessionData *SessionData
+	SignMode    AssetFileSignMode
+}
+
+// pack returns the header as written to the file, which consists of:
+// magic "BMAF" (4 bytes)
+// version (varint)
+// algorithm (varint)
+// chunk size (varint)
+// length (varint) and base58 string of the signer's account number
+// length (varint) and JSON of the session data, zero length if none
+func (h *AssetFileHeader) pack() ([]byte, error) {
+	var data []byte
+	if h.SessionData != nil {
+		var err error
+		data, err = json.Marshal(h.SessionData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	signer := h.Signer.String()
+
+	var b bytes.Buffer
+	b.Write(assetFileMagic)
+	b.Write(util.ToVarint64(uint64(h.Version)))
+	b.Write(util.ToVarint64(uint64(h.Algorithm)))
+	b.Write(util.ToVarint64(uint64(h.ChunkSize)))
+	b.Write(util.ToVarint64(uint64(len(signer))))
+	b.WriteString(signer)
+	b.Write(util.ToVarint64(uint64(len(data))))
+	b.Write(data)
+
+	return b.Bytes(), nil
+}
+
+// WriteAssetFile encrypts an asset file from src into a self describing
+// container written to dst. The session data, if given, is embedded so
+// that the recipient can recover the session key from the file itself.
+func WriteAssetFile(dst io.Writer, src io.Reader, key SessionKey, signer AuthKey, data *SessionData) error {
+	return WriteAssetFileWithMode(dst, src, key, signer, data, SignPlaintext)
+}
+
+// WriteAssetFileWithMode is WriteAssetFile with the content signed
+// according to mode, as EncryptAssetFileStreamWithMode does
+func WriteAssetFileWithMode(dst io.Writer, src io.Reader, key SessionKey, signer AuthKey, data *SessionData, mode AssetFileSignMode) error {
+	alg, err := sessionKeyAlgorithm(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	h := &AssetFileHeader{
+		Version:     AssetFileVersion1,
+		Algorithm:   alg,
+		ChunkSize:   AssetFileChunkSize,
+		Signer:      signer.PublicKey(),
+		SessionData: data,
+		SignMode:    mode,
+	}
+	header, err := h.pack()
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	// the header is authenticated with the content, so none of its
+	// fields can be changed without breaking the file
+	return encryptStream(dst, src, aead, h.ChunkSize, signer.PrivateKeyBytes(), header, mode)
+}
+
+// AssetFileReader reads an encrypted asset file, either a container
+// written by WriteAssetFile or a headerless file made by
+// EncryptAssetFileWithMode or EncryptAssetFileStreamWithMode.
+type AssetFileReader struct {
+	Header AssetFileHeader
+
+	raw []byte
+	src *bufio.Reader
+}
+
+// NewAssetFileReader reads the header of an asset file. The header of a
+// headerless file only has its Version set to AssetFileLegacy, and its
+// SignMode.
+func NewAssetFileReader(src io.Reader) (*AssetFileReader, error) {
+	r := &AssetFileReader{
+		src: bufio.NewReader(src),
+	}
+
+	magic, err := r.src.Peek(len(assetFileMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, assetFileMagic) {
+		r.Header.Version = AssetFileLegacy
+		r.Header.SignMode = r.peekSignMode()
+		return r, nil
+	}
+
+	// keep the raw header, which is authenticated with the content
+	tee := &headerReader{src: r.src}
+	if _, err := io.ReadFull(tee, make([]byte, len(assetFileMagic))); err != nil {
+		return nil, err
+	}
+
+	version, err := readVarint(tee)
+	if err != nil {
+		return nil, err
+	}
+	if version != AssetFileVersion1 {
+		return nil, ErrAssetFileVersion
+	}
+	algorithm, err := readVarint(tee)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize, err := readVarint(tee)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize == 0 || chunkSize > maxAssetFileChunkSize {
+		return nil, ErrAssetFileHeader
+	}
+	signer, err := readField(tee)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readField(tee)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header = AssetFileHeader{
+		Version:   int(version),
+		Algorithm: int(algorithm),
+		ChunkSize: int(chunkSize),
+	}
+	r.Header.Signer, err = account.AccountFromBase58(string(signer))
+	if err != nil {
+		return nil, ErrAssetFileHeader
+	}
+	if len(data) != 0 {
+		r.Header.SessionData = &SessionData{}
+		if err := json.Unmarshal(data, r.Header.SessionData); err != nil {
+			return nil, err
+		}
+	}
+
+	r.Header.SignMode = r.peekSignMode()
+	r.raw = tee.raw.Bytes()
+	return r, nil
+}
+
+func (r *AssetFileReader) peekSignMode() AssetFileSignMode {
+	header, _ := r.src.Peek(len(streamMagic2) + 1)
+	return streamSignMode(header)
+}
+
+// Decrypt decrypts the content of the asset file to dst and checks that
+// it is signed by the owner of authPubkey. As with
+// DecryptAssetFileStream, the output must be discarded on error.
+func (r *AssetFileReader) Decrypt(dst io.Writer, key SessionKey, authPubkey []byte) error {
+	if r.Header.Version == AssetFileLegacy {
+		return r.decryptLegacy(dst, key, authPubkey)
+	}
+
+	if !bytes.Equal(r.Header.Signer.PublicKeyBytes(), authPubkey) {
+		return ErrAssetFileSigner
+	}
+
+	alg, err := sessionKeyAlgorithm(key)
+	if err != nil {
+		return err
+	}
+	if alg != r.Header.Algorithm {
+		return ErrAssetFileAlgorithm
+	}
+
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	return decryptStream(dst, r.src, aead, r.Header.ChunkSize, authPubkey, r.raw)
+}
+
+// decryptLegacy decrypts a headerless file. A stream made by
+// EncryptAssetFileStreamWithMode is decrypted as it is read. Content
+// made by EncryptAssetFile is sealed as a whole, so it is read into
+// memory. The two can start with the same bytes, so the first chunk of
+// a stream is opened to tell them apart.
+func (r *AssetFileReader) decryptLegacy(dst io.Writer, key SessionKey, authPubkey []byte) error {
+	var src io.Reader = r.src
+	if aead, err := newStreamAEAD(key); err == nil {
+		start := make([]byte, streamProbeSize(aead, AssetFileChunkSize))
+		n, err := io.ReadFull(r.src, start)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		start = start[:n]
+		src = io.MultiReader(bytes.NewReader(start), r.src)
+
+		if probeStream(start, aead, AssetFileChunkSize) {
+			return decryptStream(dst, src, aead, AssetFileChunkSize, authPubkey, nil)
+		}
+	}
+
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := DecryptAssetFile(content, key, authPubkey)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(plaintext)
+	return err
+}
+
+// Verify checks the signature over the ciphertext of an asset file
+// signed with SignCiphertext or SignPlaintextAndCiphertext, without the
+// session key. Like Decrypt, it reads the rest of the file.
+func (r *AssetFileReader) Verify(authPubkey []byte) error {
+	if r.Header.Version != AssetFileLegacy && !bytes.Equal(r.Header.Signer.PublicKeyBytes(), authPubkey) {
+		return ErrAssetFileSigner
+	}
+	return verifyStream(r.src, authPubkey, r.raw)
+}
+
+// headerReader records the bytes read from src
+type headerReader struct {
+	src *bufio.Reader
+	raw bytes.Buffer
+}
+
+func (r *headerReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.raw.Write(p[:n])
+	return n, err
+}
+
+func (r *headerReader) ReadByte() (byte, error) {
+	c, err := r.src.ReadByte()
+	if err == nil {
+		r.raw.WriteByte(c)
+	}
+	return c, err
+}
+
+func readVarint(r io.ByteReader) (uint64, error) {
+	var b []byte
+	for i := 0; i < 10; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, ErrAssetFileHeader
+		}
+		b = append(b, c)
+		if c&0x80 == 0 {
+			v, n := util.FromVarint64(b)
+			if n != len(b) {
+				return 0, ErrAssetFileHeader
+			}
+			return v, nil
+		}
+	}
+	return 0, ErrAssetFileHeader
+}
+
+func readField(r *headerReader) ([]byte, error) {
+	length, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxAssetFileFieldLength {
+		return nil, ErrAssetFileHeader
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, ErrAssetFileHeader
+	}
+	return b, nil
+}
@@ -0,0 +1,201 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetFileContainer(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	_, rEncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	sessKey, err := NewAES256GCMSessionKey()
+	assert.NoError(t, err)
+	data, err := CreateSessionData(sessKey, toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	content := make([]byte, AssetFileChunkSize+100)
+	rand.Read(content)
+
+	var file bytes.Buffer
+	err = WriteAssetFile(&file, bytes.NewReader(content), sessKey, sAuthKey, data)
+	assert.NoError(t, err)
+
+	// everything needed to decrypt comes from the file and the
+	// recipient's own keys
+	r, err := NewAssetFileReader(bytes.NewReader(file.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, AssetFileVersion1, r.Header.Version)
+	assert.Equal(t, Aes256gcm, r.Header.Algorithm)
+	assert.Equal(t, AssetFileChunkSize, r.Header.ChunkSize)
+	assert.Equal(t, sAuthKey.AccountNumber(), r.Header.Signer.String())
+
+	key, err := SessionKeyFromSessionData(r.Header.SessionData, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), r.Header.Signer.PublicKeyBytes())
+	assert.NoError(t, err)
+
+	var decrypted bytes.Buffer
+	err = r.Decrypt(&decrypted, key, sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+
+	// the header is authenticated, here the chunk size is changed
+	tampered := append([]byte{}, file.Bytes()...)
+	tampered[8] = 0x03
+	r, err = NewAssetFileReader(bytes.NewReader(tampered))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Error(t, r.Decrypt(&bytes.Buffer{}, key, sAuthKey.PublicKeyBytes()))
+
+	// signed by someone else
+	other, _ := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	r, err = NewAssetFileReader(bytes.NewReader(file.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, ErrAssetFileSigner, r.Decrypt(&bytes.Buffer{}, key, other.PublicKeyBytes()))
+}
+
+func TestAssetFileContainerSignMode(t *testing.T) {
+	sAuthKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	other, _ := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	sessKey, _ := NewChaCha20SessionKey()
+
+	content := make([]byte, AssetFileChunkSize+100)
+	rand.Read(content)
+
+	var file bytes.Buffer
+	err := WriteAssetFileWithMode(&file, bytes.NewReader(content), sessKey, sAuthKey, nil, SignPlaintextAndCiphertext)
+	assert.NoError(t, err)
+
+	r, err := NewAssetFileReader(bytes.NewReader(file.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, SignPlaintextAndCiphertext, r.Header.SignMode)
+	assert.NoError(t, r.Verify(sAuthKey.PublicKeyBytes()))
+
+	r, _ = NewAssetFileReader(bytes.NewReader(file.Bytes()))
+	var decrypted bytes.Buffer
+	assert.NoError(t, r.Decrypt(&decrypted, sessKey, sAuthKey.PublicKeyBytes()))
+	assert.Equal(t, content, decrypted.Bytes())
+
+	r, _ = NewAssetFileReader(bytes.NewReader(file.Bytes()))
+	assert.Equal(t, ErrAssetFileSigner, r.Verify(other.PublicKeyBytes()))
+
+	// the container header is covered by the signature over the ciphertext
+	tampered := append([]byte{}, file.Bytes()...)
+	tampered[8] = 0x03
+	r, err = NewAssetFileReader(bytes.NewReader(tampered))
+	if assert.NoError(t, err) {
+		assert.Error(t, r.Verify(sAuthKey.PublicKeyBytes()))
+	}
+
+	// headerless files signed over the ciphertext are read too
+	encrypted, err := EncryptAssetFileWithMode(content, sessKey, sAuthKey.PrivateKeyBytes(), SignCiphertext)
+	assert.NoError(t, err)
+	r, err = NewAssetFileReader(bytes.NewReader(encrypted))
+	assert.NoError(t, err)
+	assert.Equal(t, AssetFileLegacy, r.Header.Version)
+	assert.Equal(t, SignCiphertext, r.Header.SignMode)
+	decrypted.Reset()
+	assert.NoError(t, r.Decrypt(&decrypted, sessKey, sAuthKey.PublicKeyBytes()))
+	assert.Equal(t, content, decrypted.Bytes())
+
+	var plain bytes.Buffer
+	WriteAssetFile(&plain, bytes.NewReader(content), sessKey, sAuthKey, nil)
+	r, _ = NewAssetFileReader(bytes.NewReader(plain.Bytes()))
+	assert.Equal(t, SignPlaintext, r.Header.SignMode)
+	assert.Equal(t, ErrStreamSignMode, r.Verify(sAuthKey.PublicKeyBytes()))
+}
+
+func TestAssetFileContainerLegacy(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, _ := NewChaCha20SessionKey()
+
+	content := []byte("Hello, world!")
+	encrypted, err := EncryptAssetFile(content, sessKey, authKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	r, err := NewAssetFileReader(bytes.NewReader(encrypted))
+	assert.NoError(t, err)
+	assert.Equal(t, AssetFileLegacy, r.Header.Version)
+
+	var decrypted bytes.Buffer
+	assert.NoError(t, r.Decrypt(&decrypted, sessKey, authKey.PublicKeyBytes()))
+	assert.Equal(t, content, decrypted.Bytes())
+}
+
+// countingReader counts the bytes read from src
+type countingReader struct {
+	src io.Reader
+	n   int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestAssetFileContainerLegacyStream(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	chachaKey, _ := NewChaCha20SessionKey()
+	aesKey, _ := NewAES256GCMSessionKey()
+
+	for _, sessKey := range []SessionKey{chachaKey, aesKey} {
+		for _, size := range []int{0, 100, AssetFileChunkSize, 4*AssetFileChunkSize + 100} {
+			content := make([]byte, size)
+			rand.Read(content)
+
+			var b bytes.Buffer
+			assert.NoError(t, EncryptAssetFileStream(&b, bytes.NewReader(content), sessKey, authKey.PrivateKeyBytes()))
+
+			src := &countingReader{src: bytes.NewReader(b.Bytes())}
+			r, err := NewAssetFileReader(src)
+			assert.NoError(t, err)
+			assert.Equal(t, AssetFileLegacy, r.Header.Version)
+
+			// the first chunk is written before the whole file is read
+			var decrypted bytes.Buffer
+			read := -1
+			dst := writerFunc(func(p []byte) (int, error) {
+				if read < 0 && len(p) > 0 {
+					read = src.n
+				}
+				return decrypted.Write(p)
+			})
+			assert.NoError(t, r.Decrypt(dst, sessKey, authKey.PublicKeyBytes()), "size: %d", size)
+			assert.True(t, bytes.Equal(content, decrypted.Bytes()), "size: %d", size)
+			if size > 2*AssetFileChunkSize {
+				assert.True(t, read < b.Len(), "read %d of %d", read, b.Len())
+			}
+
+			// a tampered stream is not taken for any other format
+			tampered := append([]byte{}, b.Bytes()...)
+			tampered[len(tampered)/2] ^= 0x01
+			r, _ = NewAssetFileReader(bytes.NewReader(tampered))
+			assert.Error(t, r.Decrypt(&bytes.Buffer{}, sessKey, authKey.PublicKeyBytes()))
+		}
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestAssetFileContainerInvalidHeader(t *testing.T) {
+	for _, b := range [][]byte{
+		[]byte("BMAF"),
+		[]byte("BMAF\x02"),
+		[]byte("BMAF\x01\x00\x00"),
+		[]byte("BMAF\x01\x00\x80\x80\x80\x80\x80\x80\x80\x80\x80\x80"),
+		[]byte("BMAF\x01\x00\x01\xff\xff\x03"),
+		[]byte("BMAF\x01\x00\x01\x03abc\x00"),
+	} {
+		_, err := NewAssetFileReader(bytes.NewReader(b))
+		assert.Error(t, err, "%q", b)
+	}
+}
@@ -2,53 +2,151 @@ package bitmarklib
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
 
+	"github.com/bitmark-inc/bitmarkd/util"
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/ed25519"
-	"golang.org/x/crypto/nacl/box"
 )
 
 const (
 	Chacha20poly1305 = iota
+	Aes256gcm
+)
+
+const (
+	sessionFrameVersion1 = 0x01
+
+	assetFileContextVersion1 = 1
+)
+
+const (
+	// SessionDataVersion1 is the first version of SessionData which
+	// records the algorithm of its session key. SessionData without a
+	// version always carries a Chacha20poly1305 key, and is still what
+	// CreateSessionData returns for one, so that verifiers which only
+	// know the unversioned format keep working.
+	SessionDataVersion1 = 1
+)
+
+var (
+	ErrSessionDataVersion = errors.New("unsupported session data version")
 )
 
 type SessionData struct {
 	EncryptedSessionKey          []byte
 	EncryptedSessionKeySignature []byte
 	SessionKeySignature          []byte
+	Algorithm                    int
+	Version                      int
 }
 
 func (d *SessionData) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&struct {
+	aux := struct {
 		EncryptedSessionKey          string `json:"enc_skey"`
 		EncryptedSessionKeySignature string `json:"enc_skey_sig"`
 		SessionKeySignature          string `json:"skey_sig"`
+		Algorithm                    *int   `json:"alg,omitempty"`
+		Version                      *int   `json:"version,omitempty"`
 	}{
 		EncryptedSessionKey:          hex.EncodeToString(d.EncryptedSessionKey),
 		EncryptedSessionKeySignature: hex.EncodeToString(d.EncryptedSessionKeySignature),
 		SessionKeySignature:          hex.EncodeToString(d.SessionKeySignature),
-	})
+	}
+
+	// keep the output of unversioned session data unchanged
+	if d.Version != 0 {
+		aux.Algorithm = &d.Algorithm
+		aux.Version = &d.Version
+	}
+
+	return json.Marshal(&aux)
 }
 
 func (d *SessionData) UnmarshalJSON(data []byte) error {
 	var aux struct {
-		EncryptedSessKey          string `json:"enc_skey"`
-		EncryptedSessKeySignature string `json:"enc_skey_sig"`
-		SessKeySignature          string `json:"skey_sig"`
+		EncryptedSessKey          *string `json:"enc_skey"`
+		EncryptedSessKeySignature *string `json:"enc_skey_sig"`
+		SessKeySignature          *string `json:"skey_sig"`
+		Algorithm                 *int    `json:"alg"`
+		Version                   *int    `json:"version"`
 	}
 
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
 
-	d.EncryptedSessionKey, _ = hex.DecodeString(aux.EncryptedSessKey)
-	d.EncryptedSessionKeySignature, _ = hex.DecodeString(aux.EncryptedSessKeySignature)
-	d.SessionKeySignature, _ = hex.DecodeString(aux.SessKeySignature)
+	if aux.EncryptedSessKey == nil || aux.EncryptedSessKeySignature == nil || aux.SessKeySignature == nil {
+		return errors.New("missing session data field")
+	}
+
+	encryptedSessionKey, err := hex.DecodeString(*aux.EncryptedSessKey)
+	if err != nil {
+		return errors.New("invalid encrypted session key")
+	}
+	encryptedSessionKeySignature, err := hex.DecodeString(*aux.EncryptedSessKeySignature)
+	if err != nil {
+		return errors.New("invalid encrypted session key signature")
+	}
+	sessionKeySignature, err := hex.DecodeString(*aux.SessKeySignature)
+	if err != nil {
+		return errors.New("invalid session key signature")
+	}
+
+	version := 0
+	algorithm := Chacha20poly1305
+	switch {
+	case aux.Version == nil && aux.Algorithm == nil:
+	case aux.Version == nil:
+		return errors.New("missing session data version")
+	case *aux.Version != SessionDataVersion1:
+		return ErrSessionDataVersion
+	case aux.Algorithm == nil:
+		return errors.New("missing session data algorithm")
+	default:
+		version = *aux.Version
+		algorithm = *aux.Algorithm
+	}
+
+	d.EncryptedSessionKey = encryptedSessionKey
+	d.EncryptedSessionKeySignature = encryptedSessionKeySignature
+	d.SessionKeySignature = sessionKeySignature
+	d.Algorithm = algorithm
+	d.Version = version
+	return nil
+}
+
+// packEncryptedKey returns the signed form of the encrypted session key.
+// Versioned session data signs its version and algorithm (varint)
+// followed by the encrypted session key, so that neither can be changed
+// without the sender. Unversioned session data signs the encrypted
+// session key alone.
+func (d *SessionData) packEncryptedKey() []byte {
+	if d.Version == 0 {
+		return d.EncryptedSessionKey
+	}
+
+	var b bytes.Buffer
+	b.Write(util.ToVarint64(uint64(d.Version)))
+	b.Write(util.ToVarint64(uint64(d.Algorithm)))
+	b.Write(d.EncryptedSessionKey)
+	return b.Bytes()
+}
+
+// Verify checks the sender's signature over the encrypted session key
+func (d *SessionData) Verify(sAuthPubkey []byte) error {
+	if len(sAuthPubkey) != ed25519.PublicKeySize {
+		return errors.New("invalid auth public key size")
+	}
+
+	if !ed25519.Verify(sAuthPubkey, d.packEncryptedKey(), d.EncryptedSessionKeySignature) {
+		return errors.New("invalid encrypted session key signature")
+	}
 	return nil
 }
 
@@ -59,37 +157,74 @@ type SessionKey interface {
 	Decrypt([]byte) ([]byte, error)
 }
 
+// AEADSessionKey is a SessionKey which can also authenticate additional
+// data that is not encrypted, so a ciphertext can be bound to a context.
+type AEADSessionKey interface {
+	SessionKey
+	EncryptWithAdditionalData(plaintext, additionalData []byte) ([]byte, error)
+	DecryptWithAdditionalData(ciphertext, additionalData []byte) ([]byte, error)
+}
+
 func SessionKeyFromHex(alg int, sessionKey string) (SessionKey, error) {
 	key, err := hex.DecodeString(sessionKey)
 	if err != nil {
 		return nil, errors.New("invalid session key")
 	}
 
+	return newSessionKey(alg, key)
+}
+
+func newSessionKey(alg int, key []byte) (SessionKey, error) {
 	switch alg {
 	case Chacha20poly1305:
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, errors.New("invalid session key size")
+		}
 		return &ChaCha20SessionKey{key: key}, nil
+	case Aes256gcm:
+		if len(key) != aes256KeySize {
+			return nil, errors.New("invalid session key size")
+		}
+		return &AES256GCMSessionKey{key: key}, nil
 	default:
 		return nil, errors.New("unsupported algorithm")
 	}
 }
 
+// sessionKeyAlgorithm returns the algorithm constant of a session key
+func sessionKeyAlgorithm(key SessionKey) (int, error) {
+	switch key.(type) {
+	case *ChaCha20SessionKey:
+		return Chacha20poly1305, nil
+	case *AES256GCMSessionKey:
+		return Aes256gcm, nil
+	default:
+		return 0, errors.New("unsupported algorithm")
+	}
+}
+
 func SessionKeyFromSessionData(data *SessionData, sEncryptionPubkey, rEncryptionPvtkey *[32]byte, sAuthPubkey []byte) (SessionKey, error) {
-	if !ed25519.Verify(sAuthPubkey, data.EncryptedSessionKey, data.EncryptedSessionKeySignature) {
-		return nil, errors.New("invalid encrypted session key signature")
+	if err := data.Verify(sAuthPubkey); err != nil {
+		return nil, err
 	}
 
-	var decryptNonce [24]byte
-	copy(decryptNonce[:], data.EncryptedSessionKey[:24])
-	decrypted, ok := box.Open(nil, data.EncryptedSessionKey[24:], &decryptNonce, sEncryptionPubkey, rEncryptionPvtkey)
-	if !ok {
-		return nil, errors.New("unable to decrypt")
+	decrypted, err := openSessionKey(data.EncryptedSessionKey, sEncryptionPubkey, rEncryptionPvtkey)
+	if err != nil {
+		return nil, err
 	}
 
 	if !ed25519.Verify(sAuthPubkey, decrypted, data.SessionKeySignature) {
 		return nil, errors.New("invalid session key signature")
 	}
 
-	return &ChaCha20SessionKey{key: decrypted}, nil
+	switch data.Version {
+	case 0:
+		return newSessionKey(Chacha20poly1305, decrypted)
+	case SessionDataVersion1:
+		return newSessionKey(data.Algorithm, decrypted)
+	default:
+		return nil, ErrSessionDataVersion
+	}
 }
 
 type ChaCha20SessionKey struct {
@@ -113,8 +248,80 @@ func (k *ChaCha20SessionKey) Bytes() []byte {
 	return k.key
 }
 
-// Encrypt the plaintext using zero nonce
+// Encrypt the plaintext with XChaCha20-Poly1305 using a random nonce.
+// The ciphertext is framed as:
+// version of the frame (1 byte)
+// nonce (24 bytes)
+// sealed plaintext
 func (k *ChaCha20SessionKey) Encrypt(plaintext []byte) ([]byte, error) {
+	return k.EncryptWithAdditionalData(plaintext, nil)
+}
+
+// Decrypt the ciphertext produced by Encrypt. Ciphertext which is not
+// framed is decrypted by LegacyDecrypt.
+func (k *ChaCha20SessionKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	return k.DecryptWithAdditionalData(ciphertext, nil)
+}
+
+// EncryptWithAdditionalData is Encrypt, with the additional data
+// authenticated along with the frame version
+func (k *ChaCha20SessionKey) EncryptWithAdditionalData(plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealFrame(aead, plaintext, additionalData)
+}
+
+// DecryptWithAdditionalData decrypts the ciphertext produced by
+// EncryptWithAdditionalData. The legacy format carries no additional
+// data, so it is only accepted when additionalData is empty.
+func (k *ChaCha20SessionKey) DecryptWithAdditionalData(ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openFrame(aead, ciphertext, additionalData)
+	if err == nil || len(additionalData) != 0 {
+		return plaintext, err
+	}
+
+	return k.LegacyDecrypt(ciphertext)
+}
+
+// sealFrame encrypts the plaintext with a random nonce into a frame of:
+// version of the frame (1 byte)
+// nonce
+// sealed plaintext
+// The version is authenticated along with the additional data.
+func sealFrame(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	headerSize := 1 + aead.NonceSize()
+	header := make([]byte, headerSize, headerSize+len(plaintext)+aead.Overhead())
+	header[0] = sessionFrameVersion1
+	if _, err := io.ReadFull(rand.Reader, header[1:]); err != nil {
+		return nil, err
+	}
+
+	ad := append(header[:1:1], additionalData...)
+	return aead.Seal(header, header[1:], plaintext, ad), nil
+}
+
+// openFrame decrypts a frame produced by sealFrame
+func openFrame(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	headerSize := 1 + aead.NonceSize()
+	if len(ciphertext) < headerSize+aead.Overhead() || ciphertext[0] != sessionFrameVersion1 {
+		return nil, errors.New("ciphertext is not framed")
+	}
+
+	ad := append(ciphertext[:1:1], additionalData...)
+	return aead.Open(nil, ciphertext[1:headerSize], ciphertext[headerSize:], ad)
+}
+
+// LegacyEncrypt encrypts the plaintext using zero nonce. A session key
+// must not be used to encrypt more than one message in this mode.
+func (k *ChaCha20SessionKey) LegacyEncrypt(plaintext []byte) ([]byte, error) {
 	aead, err := chacha20poly1305.New(k.key)
 	if err != nil {
 		return nil, err
@@ -127,8 +334,8 @@ func (k *ChaCha20SessionKey) Encrypt(plaintext []byte) ([]byte, error) {
 	return ciphertext, nil
 }
 
-// Decrypt the ciphertext using zero nonce
-func (k *ChaCha20SessionKey) Decrypt(ciphertext []byte) ([]byte, error) {
+// LegacyDecrypt decrypts the ciphertext using zero nonce
+func (k *ChaCha20SessionKey) LegacyDecrypt(ciphertext []byte) ([]byte, error) {
 	aead, err := chacha20poly1305.New(k.key)
 	if err != nil {
 		return nil, err
@@ -163,17 +370,163 @@ func EncryptAssetFile(content []byte, key SessionKey, authPvtkey []byte) ([]byte
 
 // DecryptAssetFile decrypts encrypted asset file content and verifies the included signature
 func DecryptAssetFile(content []byte, key SessionKey, authPubkey []byte) ([]byte, error) {
+	ciphertext, signature, err := splitAssetFile(content, authPubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := key.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(authPubkey, plaintext, signature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return plaintext, nil
+}
+
+func splitAssetFile(content []byte, authPubkey []byte) ([]byte, []byte, error) {
+	if len(authPubkey) != ed25519.PublicKeySize {
+		return nil, nil, errors.New("invalid auth public key size")
+	}
+
 	if len(content) < ed25519.SignatureSize {
-		return nil, errors.New("invalid encrypted file size")
+		return nil, nil, errors.New("invalid encrypted file size")
 	}
 	ciphertext := content[:len(content)-ed25519.SignatureSize]
 	signature := content[len(content)-ed25519.SignatureSize:]
 
-	if len(signature) != ed25519.SignatureSize {
-		return nil, errors.New("invalid signature size")
+	return ciphertext, signature, nil
+}
+
+// AssetFileSignMode chooses what the signature of an encrypted asset
+// file covers
+type AssetFileSignMode int
+
+const (
+	// SignPlaintext signs the asset file in plaintext, as EncryptAssetFile does
+	SignPlaintext AssetFileSignMode = iota
+	// SignCiphertext signs the ciphertext, so the file can be verified
+	// without the session key
+	SignCiphertext
+	// SignPlaintextAndCiphertext signs the plaintext as EncryptAssetFile
+	// does, then signs the result
+	SignPlaintextAndCiphertext
+)
+
+// EncryptAssetFileWithMode generates encrypted asset file content signed
+// according to mode. SignPlaintext gives the content of
+// EncryptAssetFile. Other modes give a stream of
+// EncryptAssetFileStreamWithMode, which records the mode in its header,
+// and whose last signature VerifyEncryptedAssetFile checks.
+func EncryptAssetFileWithMode(content []byte, key SessionKey, authPvtkey []byte, mode AssetFileSignMode) ([]byte, error) {
+	if mode == SignPlaintext {
+		return EncryptAssetFile(content, key, authPvtkey)
 	}
 
-	plaintext, err := key.Decrypt(ciphertext)
+	var b bytes.Buffer
+	if err := EncryptAssetFileStreamWithMode(&b, bytes.NewReader(content), key, authPvtkey, mode); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecryptAssetFileWithMode decrypts encrypted asset file content
+// produced by EncryptAssetFileWithMode and verifies its signatures. It
+// returns ErrStreamSignMode if the content is signed with another mode.
+func DecryptAssetFileWithMode(content []byte, key SessionKey, authPubkey []byte, mode AssetFileSignMode) ([]byte, error) {
+	if mode == SignPlaintext {
+		plaintext, err := DecryptAssetFile(content, key, authPubkey)
+		if err != nil && AssetFileSignModeOf(content) != SignPlaintext {
+			return nil, ErrStreamSignMode
+		}
+		return plaintext, err
+	}
+	if AssetFileSignModeOf(content) != mode {
+		return nil, ErrStreamSignMode
+	}
+
+	var b bytes.Buffer
+	if err := DecryptAssetFileStream(&b, bytes.NewReader(content), key, authPubkey); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// AssetFileSignModeOf returns the sign mode recorded in encrypted asset
+// file content produced by EncryptAssetFileWithMode. Content made by
+// EncryptAssetFile has no header, so it could start with the header of
+// another mode by chance, which DecryptAssetFileWithMode allows for.
+func AssetFileSignModeOf(content []byte) AssetFileSignMode {
+	return streamSignMode(content)
+}
+
+// VerifyEncryptedAssetFile checks the signature over the ciphertext of
+// an asset file encrypted with SignCiphertext or
+// SignPlaintextAndCiphertext, without decrypting it
+func VerifyEncryptedAssetFile(content []byte, authPubkey []byte) error {
+	return VerifyAssetFileStream(bytes.NewReader(content), authPubkey)
+}
+
+// AssetFileContext is the context an encrypted asset file belongs to.
+// It is authenticated as additional data, so the file can not be
+// decrypted under any other context.
+type AssetFileContext struct {
+	BitmarkId   string
+	AssetId     string
+	Fingerprint string
+}
+
+// Bytes returns the additional data of the context, which consists of:
+// version of the context format (varint)
+// length (varint) and bytes of the bitmark id, asset id and fingerprint
+func (c AssetFileContext) Bytes() []byte {
+	b := util.ToVarint64(assetFileContextVersion1)
+	for _, field := range []string{c.BitmarkId, c.AssetId, c.Fingerprint} {
+		b = append(b, util.ToVarint64(uint64(len(field)))...)
+		b = append(b, field...)
+	}
+	return b
+}
+
+// EncryptAssetFileWithContext is EncryptAssetFile with the ciphertext
+// bound to the context. The session key must be an AEADSessionKey.
+func EncryptAssetFileWithContext(content []byte, key SessionKey, authPvtkey []byte, context AssetFileContext) ([]byte, error) {
+	aeadKey, ok := key.(AEADSessionKey)
+	if !ok {
+		return nil, errors.New("session key does not support additional data")
+	}
+
+	ciphertext, err := aeadKey.EncryptWithAdditionalData(content, context.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	signature := ed25519.Sign(authPvtkey, content)
+
+	b := bytes.NewBuffer(ciphertext)
+	b.Write(signature)
+
+	return b.Bytes(), nil
+}
+
+// DecryptAssetFileWithContext decrypts encrypted asset file content
+// produced by EncryptAssetFileWithContext. It fails if the content was
+// encrypted under another context.
+func DecryptAssetFileWithContext(content []byte, key SessionKey, authPubkey []byte, context AssetFileContext) ([]byte, error) {
+	aeadKey, ok := key.(AEADSessionKey)
+	if !ok {
+		return nil, errors.New("session key does not support additional data")
+	}
+
+	ciphertext, signature, err := splitAssetFile(content, authPubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aeadKey.DecryptWithAdditionalData(ciphertext, context.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -185,17 +538,42 @@ func DecryptAssetFile(content []byte, key SessionKey, authPubkey []byte) ([]byte
 	return plaintext, nil
 }
 
-// CreateSessionData creates the SessionData of a SessionKey
+// CreateSessionData creates the SessionData of a SessionKey. Only the
+// SessionData of a key other than Chacha20poly1305 is versioned.
 func CreateSessionData(sessKey SessionKey, recipientEncryptionPubkey, senderEncryptionPvtkey *[32]byte, senderAuthPvtkey []byte) (*SessionData, error) {
-	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		panic(err)
+	alg, err := sessionKeyAlgorithm(sessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := sealSessionKey(sessKey, recipientEncryptionPubkey, senderEncryptionPvtkey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &SessionData{
+		EncryptedSessionKey: encryptedKey,
+		SessionKeySignature: ed25519.Sign(senderAuthPvtkey, sessKey.Bytes()),
+		Algorithm:           alg,
+	}
+	if alg != Chacha20poly1305 {
+		d.Version = SessionDataVersion1
+	}
+	d.EncryptedSessionKeySignature = ed25519.Sign(senderAuthPvtkey, d.packEncryptedKey())
+	return d, nil
+}
+
+// RewrapSessionData decrypts the session key of old, which was sent to
+// the owner, and creates the SessionData of the same key from the owner
+// to the new owner
+func RewrapSessionData(old *SessionData, senderEncryptionPubkey *[32]byte, senderAuthPubkey []byte, ownerKeys *AccountKeys, newOwnerEncryptionPubkey *[32]byte) (*SessionData, error) {
+	var ownerEncryptionPvtkey [32]byte
+	copy(ownerEncryptionPvtkey[:], ownerKeys.EncrKey.PrivateKeyBytes())
+
+	sessKey, err := SessionKeyFromSessionData(old, senderEncryptionPubkey, &ownerEncryptionPvtkey, senderAuthPubkey)
+	if err != nil {
+		return nil, err
 	}
-	encryptedKey := box.Seal(nonce[:], sessKey.Bytes(), &nonce, recipientEncryptionPubkey, senderEncryptionPvtkey)
 
-	return &SessionData{
-		EncryptedSessionKey:          encryptedKey,
-		EncryptedSessionKeySignature: ed25519.Sign(senderAuthPvtkey, encryptedKey),
-		SessionKeySignature:          ed25519.Sign(senderAuthPvtkey, sessKey.Bytes()),
-	}, nil
+	return CreateSessionData(sessKey, newOwnerEncryptionPubkey, &ownerEncryptionPvtkey, ownerKeys.AuthKey.PrivateKeyBytes())
 }
@@ -0,0 +1,374 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+func mustSeedKeys(s string) (AuthKey, EncrKey) {
+	seed, err := SeedFromBase58(s)
+	if err != nil {
+		panic(err)
+	}
+	authKey, err := NewAuthKey(seed)
+	if err != nil {
+		panic(err)
+	}
+	encrKey, err := NewEncrKey(seed)
+	if err != nil {
+		panic(err)
+	}
+	return authKey, encrKey
+}
+
+func toKey32(b []byte) *[32]byte {
+	var k [32]byte
+	copy(k[:], b)
+	return &k
+}
+
+func TestAssetFileEncryption(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	content := []byte("Hello, world!")
+	encrypted, err := EncryptAssetFile(content, sessKey, authKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	plaintext, err := DecryptAssetFile(encrypted, sessKey, authKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, content, plaintext)
+
+	encrypted[0] ^= 0xff
+	_, err = DecryptAssetFile(encrypted, sessKey, authKey.PublicKeyBytes())
+	assert.Error(t, err)
+}
+
+func TestSessionData(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	_, rEncrKey := mustSeedKeys("5XEECqbX3HpUum7DiRNTRuqWkg8NrFvFDM8GLpckebep6cDgM5eHqzd")
+
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	data, err := CreateSessionData(sessKey, toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	b, err := json.Marshal(data)
+	assert.NoError(t, err)
+
+	var decoded SessionData
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+
+	key, err := SessionKeyFromSessionData(&decoded, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, sessKey.Bytes(), key.Bytes())
+}
+
+func FuzzSessionKeyFromHex(f *testing.F) {
+	f.Add(Chacha20poly1305, "3e96d0993e1c4291899f2867c77eca73f2645d8488c2ce6012b4328cc00abf01")
+	f.Add(Chacha20poly1305, "")
+	f.Add(-1, "zz")
+
+	f.Fuzz(func(t *testing.T, alg int, s string) {
+		key, err := SessionKeyFromHex(alg, s)
+		if err != nil {
+			return
+		}
+
+		ciphertext, err := key.Encrypt([]byte("Hello, world!"))
+		assert.NoError(t, err)
+		_, err = key.Decrypt(ciphertext)
+		assert.NoError(t, err)
+	})
+}
+
+func FuzzSessionDataUnmarshalJSON(f *testing.F) {
+	f.Add([]byte(`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02"}`))
+	f.Add([]byte(`{"enc_skey": "key1", "enc_skey_sig": "sig1", "skey_sig": "sig2"}`))
+	f.Add([]byte(`null`))
+
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	_, rEncrKey := mustSeedKeys("5XEECqbX3HpUum7DiRNTRuqWkg8NrFvFDM8GLpckebep6cDgM5eHqzd")
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var data SessionData
+		if err := json.Unmarshal(b, &data); err != nil {
+			return
+		}
+
+		SessionKeyFromSessionData(&data, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	})
+}
+
+func FuzzDecryptAssetFile(f *testing.F) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, _ := SessionKeyFromHex(Chacha20poly1305, "3e96d0993e1c4291899f2867c77eca73f2645d8488c2ce6012b4328cc00abf01")
+
+	encrypted, _ := EncryptAssetFile([]byte("Hello, world!"), sessKey, authKey.PrivateKeyBytes())
+	f.Add(encrypted, authKey.PublicKeyBytes())
+	f.Add([]byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, content []byte, authPubkey []byte) {
+		DecryptAssetFile(content, sessKey, authPubkey)
+	})
+}
+
+func TestChaCha20SessionKeyFraming(t *testing.T) {
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	message := []byte("Hello, world!")
+	c1, err := sessKey.Encrypt(message)
+	assert.NoError(t, err)
+	c2, err := sessKey.Encrypt(message)
+	assert.NoError(t, err)
+	assert.NotEqual(t, c1, c2)
+	assert.Equal(t, byte(sessionFrameVersion1), c1[0])
+
+	plaintext, err := sessKey.Decrypt(c1)
+	assert.NoError(t, err)
+	assert.Equal(t, message, plaintext)
+
+	c1[0] = 0x02
+	_, err = sessKey.Decrypt(c1)
+	assert.Error(t, err)
+}
+
+func TestChaCha20SessionKeyLegacy(t *testing.T) {
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	message := []byte("Hello, world!")
+	c1, err := sessKey.LegacyEncrypt(message)
+	assert.NoError(t, err)
+	c2, err := sessKey.LegacyEncrypt(message)
+	assert.NoError(t, err)
+	assert.Equal(t, c1, c2)
+
+	plaintext, err := sessKey.Decrypt(c1)
+	assert.NoError(t, err)
+	assert.Equal(t, message, plaintext)
+
+	plaintext, err = sessKey.LegacyDecrypt(c1)
+	assert.NoError(t, err)
+	assert.Equal(t, message, plaintext)
+}
+
+func TestAssetFileEncryptionWithContext(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	context := AssetFileContext{
+		BitmarkId:   "a5ad650ff2ed257b10c9afbc57eddf522eb8d3601e8b41c4c860f10444cc4e6c",
+		AssetId:     "ccdfe99670d5a6e5a1fbfd685034db85359a5626f56890b4fead45fc198e929015764f4b451ee242502120783b3588f69f64bf021abf07385dda7f2c603d6934",
+		Fingerprint: "01b2c3d4e5f6",
+	}
+
+	content := []byte("Hello, world!")
+	encrypted, err := EncryptAssetFileWithContext(content, sessKey, authKey.PrivateKeyBytes(), context)
+	assert.NoError(t, err)
+
+	plaintext, err := DecryptAssetFileWithContext(encrypted, sessKey, authKey.PublicKeyBytes(), context)
+	assert.NoError(t, err)
+	assert.Equal(t, content, plaintext)
+
+	moved := context
+	moved.BitmarkId = "6c4ecc4404f160c8c4418b1e60d3b82e52dfed57bcafc9107b25edf20f65ada5"
+	_, err = DecryptAssetFileWithContext(encrypted, sessKey, authKey.PublicKeyBytes(), moved)
+	assert.Error(t, err)
+
+	_, err = DecryptAssetFile(encrypted, sessKey, authKey.PublicKeyBytes())
+	assert.Error(t, err)
+
+	// a file encrypted without context can not be passed off as one
+	// with context
+	legacy, err := EncryptAssetFile(content, sessKey, authKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	_, err = DecryptAssetFileWithContext(legacy, sessKey, authKey.PublicKeyBytes(), context)
+	assert.Error(t, err)
+}
+
+func TestAssetFileContextBytes(t *testing.T) {
+	// fields are length prefixed, so moving bytes between them changes
+	// the additional data
+	c1 := AssetFileContext{BitmarkId: "ab", AssetId: "c"}
+	c2 := AssetFileContext{BitmarkId: "a", AssetId: "bc"}
+	assert.NotEqual(t, c1.Bytes(), c2.Bytes())
+	assert.Equal(t, []byte{0x01, 0x02, 'a', 'b', 0x01, 'c', 0x00}, c1.Bytes())
+}
+
+func TestRewrapSessionData(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	ownerSeed, _ := SeedFromBase58("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	ownerKeys, err := NewAccountKeys(ownerSeed)
+	assert.NoError(t, err)
+	newSeed, _ := NewSeed(SeedVersion1, Testnet)
+	newOwnerKeys, err := NewAccountKeys(newSeed)
+	assert.NoError(t, err)
+
+	sessKey, _ := NewChaCha20SessionKey()
+	old, err := CreateSessionData(sessKey, toKey32(ownerKeys.EncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	data, err := RewrapSessionData(old, toKey32(sEncrKey.PublicKeyBytes()), sAuthKey.PublicKeyBytes(), ownerKeys, toKey32(newOwnerKeys.EncrKey.PublicKeyBytes()))
+	assert.NoError(t, err)
+
+	key, err := SessionKeyFromSessionData(data, toKey32(ownerKeys.EncrKey.PublicKeyBytes()), toKey32(newOwnerKeys.EncrKey.PrivateKeyBytes()), ownerKeys.AuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, sessKey.Bytes(), key.Bytes())
+
+	// the session data was not sent to the new owner
+	_, err = RewrapSessionData(old, toKey32(sEncrKey.PublicKeyBytes()), sAuthKey.PublicKeyBytes(), newOwnerKeys, toKey32(ownerKeys.EncrKey.PublicKeyBytes()))
+	assert.Error(t, err)
+}
+
+func TestSessionDataJSON(t *testing.T) {
+	var data SessionData
+	err := json.Unmarshal([]byte(`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02"}`), &data)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00}, data.EncryptedSessionKey)
+	assert.Equal(t, Chacha20poly1305, data.Algorithm)
+	assert.Equal(t, 0, data.Version)
+
+	b, err := json.Marshal(&data)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02"}`, string(b))
+
+	data.Version = SessionDataVersion1
+	b, err = json.Marshal(&data)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02", "alg": 0, "version": 1}`, string(b))
+
+	var decoded SessionData
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, data, decoded)
+}
+
+func TestSessionDataJSONStrict(t *testing.T) {
+	for _, s := range []string{
+		`{"enc_skey": "key1", "enc_skey_sig": "01", "skey_sig": "02"}`,
+		`{"enc_skey": "00", "enc_skey_sig": "sig1", "skey_sig": "02"}`,
+		`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "sig2"}`,
+		`{"enc_skey": "00", "enc_skey_sig": "01"}`,
+		`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02", "alg": 0}`,
+		`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02", "alg": 0, "version": 2}`,
+	} {
+		var data SessionData
+		assert.Error(t, json.Unmarshal([]byte(s), &data), s)
+	}
+
+	var data SessionData
+	err := json.Unmarshal([]byte(`{"enc_skey": "00", "enc_skey_sig": "01", "skey_sig": "02", "version": 1}`), &data)
+	assert.EqualError(t, err, "missing session data algorithm")
+}
+
+func TestSessionKeyFromSessionDataAlgorithm(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	_, rEncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	// the session data of a Chacha20poly1305 key is unversioned, and
+	// signs the encrypted key alone
+	chachaKey, _ := NewChaCha20SessionKey()
+	data, err := CreateSessionData(chachaKey, toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, data.Version)
+	assert.True(t, ed25519.Verify(sAuthKey.PublicKeyBytes(), data.EncryptedSessionKey, data.EncryptedSessionKeySignature))
+	b, _ := json.Marshal(data)
+	assert.NotContains(t, string(b), "version")
+
+	sessKey, _ := NewAES256GCMSessionKey()
+	data, err = CreateSessionData(sessKey, toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, SessionDataVersion1, data.Version)
+	assert.Equal(t, Aes256gcm, data.Algorithm)
+
+	assert.NoError(t, data.Verify(sAuthKey.PublicKeyBytes()))
+
+	// the algorithm and version are signed with the encrypted key
+	data.Algorithm = Chacha20poly1305
+	_, err = SessionKeyFromSessionData(data, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.EqualError(t, err, "invalid encrypted session key signature")
+
+	data.Version = 0
+	_, err = SessionKeyFromSessionData(data, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.EqualError(t, err, "invalid encrypted session key signature")
+}
+
+func TestAssetFileSignMode(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	otherKey, _ := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	sessKey, _ := NewChaCha20SessionKey()
+	content := []byte("Hello, world!")
+
+	for _, mode := range []AssetFileSignMode{SignPlaintext, SignCiphertext, SignPlaintextAndCiphertext} {
+		encrypted, err := EncryptAssetFileWithMode(content, sessKey, authKey.PrivateKeyBytes(), mode)
+		assert.NoError(t, err)
+
+		assert.Equal(t, mode, AssetFileSignModeOf(encrypted))
+
+		plaintext, err := DecryptAssetFileWithMode(encrypted, sessKey, authKey.PublicKeyBytes(), mode)
+		assert.NoError(t, err)
+		assert.Equal(t, content, plaintext)
+
+		// the mode is read from the file, not guessed
+		other := (mode + 1) % 3
+		_, err = DecryptAssetFileWithMode(encrypted, sessKey, authKey.PublicKeyBytes(), other)
+		assert.Equal(t, ErrStreamSignMode, err)
+
+		_, err = DecryptAssetFileWithMode(encrypted, sessKey, otherKey.PublicKeyBytes(), mode)
+		assert.Error(t, err)
+
+		if mode == SignPlaintext {
+			assert.Equal(t, ErrStreamSignMode, VerifyEncryptedAssetFile(encrypted, authKey.PublicKeyBytes()))
+			continue
+		}
+
+		// the integrity of the file can be checked without the key
+		assert.NoError(t, VerifyEncryptedAssetFile(encrypted, authKey.PublicKeyBytes()))
+		assert.Error(t, VerifyEncryptedAssetFile(encrypted, otherKey.PublicKeyBytes()))
+
+		encrypted[len(encrypted)/2] ^= 0x01
+		assert.Error(t, VerifyEncryptedAssetFile(encrypted, authKey.PublicKeyBytes()))
+
+		// a changed mode breaks the signatures
+		encrypted[len(encrypted)/2] ^= 0x01
+		encrypted[len(streamMagic2)] = byte(other)
+		assert.Error(t, VerifyEncryptedAssetFile(encrypted, authKey.PublicKeyBytes()))
+	}
+}
+
+func TestAssetFileSignModeLegacy(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, _ := NewChaCha20SessionKey()
+
+	// the zero nonce ciphertext is the plaintext xor a fixed key
+	// stream, so choose plaintext whose ciphertext starts like a header
+	keyStream, _ := sessKey.LegacyEncrypt(make([]byte, 32))
+	for _, start := range [][]byte{{0x02, 0x01}, append(append([]byte{}, streamMagic2...), byte(SignCiphertext))} {
+		content := []byte("Hello, world! Hello, world!")
+		for n, b := range start {
+			content[n] = b ^ keyStream[n]
+		}
+		ciphertext, err := sessKey.LegacyEncrypt(content)
+		assert.NoError(t, err)
+		encrypted := append(ciphertext, authKey.Sign(content)...)
+		assert.Equal(t, start, encrypted[:len(start)])
+
+		plaintext, err := DecryptAssetFileWithMode(encrypted, sessKey, authKey.PublicKeyBytes(), SignPlaintext)
+		assert.NoError(t, err)
+		assert.Equal(t, content, plaintext)
+
+		r, err := NewAssetFileReader(bytes.NewReader(encrypted))
+		assert.NoError(t, err)
+		var decrypted bytes.Buffer
+		assert.NoError(t, r.Decrypt(&decrypted, sessKey, authKey.PublicKeyBytes()))
+		assert.Equal(t, content, decrypted.Bytes())
+	}
+}
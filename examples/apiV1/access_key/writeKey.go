@@ -1,86 +1,33 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"golang.org/x/crypto/ed25519"
+	"context"
 	"log"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/bitmark-inc/go-bitmarklib/api"
 )
 
-const (
-	API_URL = "https://api.devel.bitmark.com"
-)
-
-func NewSeedFromHexString(seed string) ([32]byte, error) {
-	rootSeed := [32]byte{}
-	b, err := hex.DecodeString(seed)
-	if err != nil {
-		return rootSeed, err
-	}
-
-	if len(b) != 32 {
-		return rootSeed, fmt.Errorf("invalid length of bitmark account")
-	}
-	copy(rootSeed[:], b[:32])
-	return rootSeed, nil
-}
-
 func main() {
-
-	now := time.Now().Unix()
-	accessKey := fmt.Sprintf("%064x", now)
-	seed, err := NewSeedFromHexString(accessKey)
-
-	inTest := true
-	keypair, err := bitmarklib.NewKeyPairFromSeed(seed[:], inTest, bitmarklib.ED25519)
+	seed, err := bitmarklib.NewSeed(bitmarklib.SeedVersion1, bitmarklib.Testnet)
 	if err != nil {
-		log.Fatalf("Fail to generate account key: %s", err.Error())
+		log.Fatalf("Fail to generate seed: %s", err.Error())
 	}
-	log.Printf("Auth Account: %s", keypair.Account().String())
 
-	account := keypair.Account().String()
-	accessKeyByte, _ := hex.DecodeString(accessKey)
-
-	signature := ed25519.Sign(keypair.PrivateKeyBytes(), accessKeyByte)
-	signatureString := hex.EncodeToString(signature)
-
-	u, err := url.Parse(API_URL)
+	keys, err := bitmarklib.NewAccountKeys(seed)
 	if err != nil {
-		log.Fatal(err)
-	}
-	u.Path = fmt.Sprintf("/v1/encryption_keys/%s", account)
-
-	req := map[string]string{
-		"encryption_pubkey": accessKey,
-		"signature":         signatureString,
+		log.Fatalf("Fail to generate account key: %s", err.Error())
 	}
+	log.Printf("Auth Account: %s", keys.AuthKey.AccountNumber())
 
-	var buf bytes.Buffer
-	e := json.NewEncoder(&buf)
-	err = e.Encode(req)
+	client, err := api.NewClient(api.DevelURL, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("Request Body: %s", buf.String())
-	resp, err := http.Post(u.String(), "application/json", &buf)
-	if err != nil {
-		log.Fatal(err)
+	announcement := bitmarklib.NewEncryptionKeyAnnouncement(keys)
+	if err := client.PublishEncryptionKey(context.Background(), announcement); err != nil {
+		log.Fatalf("Fail to publish encryption key: %s", err.Error())
 	}
-	log.Println("Start request from:", u.String())
-	if resp.StatusCode != 200 {
-		log.Printf("Fail to store api: %d", resp.StatusCode)
-	}
-
-	var ret map[string]interface{}
-	d := json.NewDecoder(resp.Body)
-	d.Decode(&ret)
-	log.Printf("Result: %+v\n", ret)
+	log.Printf("Published encryption key: %x", announcement.EncrPubKey)
 }
@@ -1,76 +1,59 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
-	"encoding/json"
+	"context"
 	"flag"
-	"fmt"
-	"golang.org/x/crypto/ed25519"
 	"log"
-	"net/http"
-	"net/url"
 
 	"github.com/bitmark-inc/go-bitmarklib"
-)
-
-const (
-	API_URL = "https://api.devel.bitmark.com"
+	"github.com/bitmark-inc/go-bitmarklib/api"
 )
 
 func main() {
 	bitmarkId := flag.String("bitmarkId", "", "bitmark id")
+	seedStr := flag.String("seed", "", "seed of the owner of the bitmark")
 	account := flag.String("account", "", "to account")
 	flag.Parse()
 
-	seed := "GUgLnRy3Fns6Twns2THBsZjdRWGsaDXENq18mZzHuTPy"
-	keypair, err := bitmarklib.NewKeyPairFromBase58Seed(seed, true, bitmarklib.ED25519)
+	seed, err := bitmarklib.SeedFromBase58(*seedStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	data := `{"enc_skey": "key1", "enc_skey_sig": "sig1", "skey_sig": "sig2"}`
 	if *account == "" {
-		*account = keypair.Account().String()
+		*account = keys.AuthKey.AccountNumber()
 	}
-	signatureOrigin := ed25519.Sign(keypair.PrivateKeyBytes(), []byte(data))
-	signature := hex.EncodeToString(signatureOrigin)
 
-	u, err := url.Parse(API_URL)
+	client, err := api.NewClient(api.DevelURL, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	u.Path = fmt.Sprintf("/v1/session/%s", *bitmarkId)
-	q := url.Values{
-		"account_no": []string{*account},
-	}
-	u.RawQuery = q.Encode()
+	ctx := context.Background()
 
-	reqBody := map[string]string{
-		"data":      data,
-		"signature": signature,
+	// the session key is encrypted to the published key of the recipient
+	recipient, err := client.GetEncryptionKey(ctx, *account)
+	if err != nil {
+		log.Fatalf("Fail to get encryption key: %s", err.Error())
 	}
 
-	var buf bytes.Buffer
-	e := json.NewEncoder(&buf)
-	err = e.Encode(reqBody)
+	var recipientEncrPubkey, senderEncrPvtkey [32]byte
+	copy(recipientEncrPubkey[:], recipient.EncrPubKey)
+	copy(senderEncrPvtkey[:], keys.EncrKey.PrivateKeyBytes())
+
+	sessKey, err := bitmarklib.NewChaCha20SessionKey()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	log.Printf("Request body: %+v", buf.String())
-	req, _ := http.NewRequest("PUT", u.String(), &buf)
-	resp, err := http.DefaultClient.Do(req)
+	data, err := bitmarklib.CreateSessionData(sessKey, &recipientEncrPubkey, &senderEncrPvtkey, keys.AuthKey.PrivateKeyBytes())
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println(u.String())
-	if resp.StatusCode != 200 {
-		log.Printf("Fail to store api: %d", resp.StatusCode)
-	}
 
-	var ret map[string]interface{}
-	d := json.NewDecoder(resp.Body)
-	d.Decode(&ret)
-	log.Printf("Result: %+v\n", ret)
+	if err := client.PutSessionData(ctx, *bitmarkId, *account, data, keys.AuthKey); err != nil {
+		log.Fatalf("Fail to store session data: %s", err.Error())
+	}
+	log.Printf("Stored session data of %s for %s", *bitmarkId, *account)
 }
@@ -1,86 +1,17 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
+	"time"
 
 	"github.com/bitmark-inc/go-bitmarklib"
-	"github.com/bitmark-inc/go-programs/bitmarkd-gateway/action"
+	"github.com/bitmark-inc/go-bitmarklib/rpc"
 )
 
-const (
-	BDGW_HOST = "localhost:8087"
-)
-
-func requestToken(client *http.Client) (string, error) {
-	actionUrl := url.URL{
-		Scheme: "https",
-		Host:   BDGW_HOST,
-		Path:   "/token",
-	}
-	r, err := client.Get(actionUrl.String())
-	if err != nil {
-		return "", err
-	}
-	defer r.Body.Close()
-
-	if r.StatusCode != http.StatusOK {
-		return "", fmt.Errorf(r.Status)
-	}
-
-	var v map[string]string
-	d := json.NewDecoder(r.Body)
-	err = d.Decode(&v)
-	if err != nil {
-		return "", err
-	}
-	if token, ok := v["t"]; ok {
-		return token, nil
-	} else {
-		return "", fmt.Errorf("token is not available")
-	}
-}
-
-func requestTransfer(client *http.Client, a action.Action) error {
-	actionUrl := url.URL{
-		Scheme: "https",
-		Host:   BDGW_HOST,
-		Path:   "/action",
-	}
-
-	buf := bytes.Buffer{}
-	e := json.NewEncoder(&buf)
-	err := e.Encode(&a)
-	if err != nil {
-		return err
-	}
-
-	r, err := client.Post(actionUrl.String(), "application/json", &buf)
-	if err != nil {
-		log.Fatalf("request action error: %s", err)
-	}
-	defer r.Body.Close()
-
-	resultBuf := &bytes.Buffer{}
-	_, err = io.Copy(resultBuf, r.Body)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(resultBuf.String())
-	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf(r.Status)
-	}
-	return nil
-}
-
 func main() {
 	seed := "GUgLnRy3Fns6Twns2THBsZjdRWGsaDXENq18mZzHuTPy"
 	keypair, err := bitmarklib.NewKeyPairFromBase58Seed(seed, true, bitmarklib.ED25519)
@@ -88,6 +19,9 @@ func main() {
 		log.Fatal(err)
 	}
 
+	node := flag.String("node", "localhost:2130", "address of the bitmarkd rpc port")
+	fingerprint := flag.String("fingerprint", "", "hex of the SHA3-256 fingerprint of the node certificate")
+	to := flag.String("to", "fqN6WnjUaekfrqBvvmsjVskoqXnhJ632xJPHzdSgReC6bhZGuP", "to account")
 	txId := flag.String("txId", "", "transaction id")
 	flag.Parse()
 
@@ -95,18 +29,23 @@ func main() {
 		log.Fatal("empty transaction id")
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	var pin [32]byte
+	b, err := hex.DecodeString(*fingerprint)
+	if err != nil || len(b) != len(pin) {
+		log.Fatal("invalid certificate fingerprint")
 	}
-	client := &http.Client{Transport: tr}
+	copy(pin[:], b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	token, err := requestToken(client)
+	client, err := rpc.Dial(ctx, *node, rpc.PinnedTLSConfig(pin))
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer client.Close()
 
-	newOwnerKey := "fa447039da1cb03c0e48ab48dec69769d3affce01a5565a4b64a5d920f3c21a9"
-	transfer, err := bitmarklib.NewTransfer(*txId, newOwnerKey, true)
+	transfer, err := bitmarklib.NewTransfer(*txId, *to)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -115,17 +54,9 @@ func main() {
 		log.Fatal(err)
 	}
 
-	a := action.Action{
-		Type:    "transfer",
-		Token:   token,
-		Owner:   keypair.Account().String(),
-		Payload: transfer.String(),
-	}
-
-	a.Sign(keypair.PrivateKeyBytes())
-
-	err = requestTransfer(client, a)
+	reply, err := client.Transfer(ctx, transfer)
 	if err != nil {
 		log.Fatal(err)
 	}
+	fmt.Println("transferred:", reply.TxId)
 }
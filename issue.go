@@ -89,11 +89,17 @@ func NewIssue(assetId transactionrecord.AssetIdentifier) Issue {
 	}
 }
 
+// nextNonce returns a nonce that makes an issue of the same asset by
+// the same owner a different bitmark
+func nextNonce() uint64 {
+	index := atomic.AddUint64(&nonceIndex, 1)
+	return uint64(time.Now().UTC().Unix())*1000 + index%1000
+}
+
 // Sign an issue with a keypair and write the signature into
 // Signature field
 func (i *Issue) Sign(kp *KeyPair) error {
-	atomic.AddUint64(&nonceIndex, 1)
-	i.Nonce = uint64(time.Now().UTC().Unix())*1000 + nonceIndex%1000
+	i.Nonce = nextNonce()
 	i.Owner = kp.Account()
 
 	packed, _ := i.Pack(i.Owner)
@@ -106,9 +112,18 @@ func (i *Issue) Sign(kp *KeyPair) error {
 	return err
 }
 
+// TxId returns the id of a signed issue, which is also the id of the
+// bitmark it creates
+func (i *Issue) TxId() (string, error) {
+	packed, err := i.Pack(i.Owner)
+	if err != nil {
+		return "", err
+	}
+	return txIdText(packed.MakeLink())
+}
+
 func (i *Issue) ClaimedBy(key AuthKey) error {
-	atomic.AddUint64(&nonceIndex, 1)
-	i.Nonce = uint64(time.Now().UTC().Unix())*1000 + nonceIndex%1000
+	i.Nonce = nextNonce()
 	i.Owner = key.PublicKey()
 
 	packed, err := i.Pack(i.Owner)
@@ -97,6 +97,12 @@ type EncrKey interface {
 	Decrypt(ciphertext []byte, peerPublicKey []byte) (plaintext []byte, err error)
 }
 
+// AnonymousDecrypter is an encryption key that opens ciphertexts sealed
+// by SealAnonymous
+type AnonymousDecrypter interface {
+	OpenAnonymous(ciphertext []byte) (plaintext []byte, err error)
+}
+
 type CURVE25519EncrKey struct {
 	publicKey  *[32]byte
 	privateKey *[32]byte
@@ -124,6 +130,10 @@ func (c CURVE25519EncrKey) Encrypt(plaintext []byte, peerPublicKey []byte) ([]by
 }
 
 func (c CURVE25519EncrKey) Decrypt(ciphertext []byte, peerPublicKey []byte) ([]byte, error) {
+	if len(ciphertext) < 24+box.Overhead {
+		return nil, errors.New("invalid ciphertext size")
+	}
+
 	var nonce [24]byte
 	copy(nonce[:], ciphertext[:24])
 
@@ -138,6 +148,30 @@ func (c CURVE25519EncrKey) Decrypt(ciphertext []byte, peerPublicKey []byte) ([]b
 	return plaintext, nil
 }
 
+// OpenAnonymous decrypts a ciphertext sealed to the key by SealAnonymous
+func (c CURVE25519EncrKey) OpenAnonymous(ciphertext []byte) ([]byte, error) {
+	plaintext, ok := box.OpenAnonymous(nil, ciphertext, c.publicKey, c.privateKey)
+	if !ok {
+		return nil, errors.New("decryption failed")
+	}
+
+	return plaintext, nil
+}
+
+// SealAnonymous encrypts the plaintext to the owner of an encryption
+// public key with a NaCl sealed box. The sender needs no key of their
+// own, and the recipient learns nothing about who sent it.
+func SealAnonymous(plaintext []byte, peerPublicKey []byte) ([]byte, error) {
+	if len(peerPublicKey) != 32 {
+		return nil, errors.New("invalid public key size")
+	}
+
+	var publicKey = new([32]byte)
+	copy(publicKey[:], peerPublicKey[:])
+
+	return box.SealAnonymous(nil, plaintext, publicKey, rand.Reader)
+}
+
 func NewEncrKey(s *Seed) (EncrKey, error) {
 	var seedCore = new([32]byte)
 	copy(seedCore[:], s.core)
@@ -148,3 +182,23 @@ func NewEncrKey(s *Seed) (EncrKey, error) {
 	publicKey, privateKey, err := box.GenerateKey(bytes.NewBuffer(encrSeed))
 	return CURVE25519EncrKey{publicKey, privateKey}, err
 }
+
+// AccountKeys are the keys of an account generated from its Seed
+type AccountKeys struct {
+	AuthKey AuthKey
+	EncrKey EncrKey
+}
+
+func NewAccountKeys(s *Seed) (*AccountKeys, error) {
+	authKey, err := NewAuthKey(s)
+	if err != nil {
+		return nil, err
+	}
+
+	encrKey, err := NewEncrKey(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountKeys{authKey, encrKey}, nil
+}
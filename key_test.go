@@ -81,3 +81,47 @@ func mustDecodeHex(str string) []byte {
 	}
 	return b
 }
+
+func TestEncrKeyDecryptShortInput(t *testing.T) {
+	seed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	encrKey, _ := NewEncrKey(seed)
+
+	for _, ciphertext := range [][]byte{nil, make([]byte, 23), make([]byte, 24), make([]byte, 39)} {
+		if _, err := encrKey.Decrypt(ciphertext, encrKey.PublicKeyBytes()); err == nil {
+			t.Errorf("decrypted %d bytes of ciphertext", len(ciphertext))
+		}
+	}
+}
+
+func TestSealAnonymous(t *testing.T) {
+	seed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	key, _ := NewEncrKey(seed)
+	encrKey, ok := key.(AnonymousDecrypter)
+	if !ok {
+		t.Fatal("encryption key can not open sealed boxes")
+	}
+
+	message := "Hello, world!"
+	ciphertext, err := SealAnonymous([]byte(message), key.PublicKeyBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := encrKey.OpenAnonymous(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != message {
+		t.Error("anonymous encryption/decryption failed")
+	}
+
+	other, _ := NewSeed(SeedVersion1, Testnet)
+	otherKey, _ := NewEncrKey(other)
+	if _, err := otherKey.(AnonymousDecrypter).OpenAnonymous(ciphertext); err == nil {
+		t.Error("opened by the wrong recipient")
+	}
+
+	if _, err := encrKey.OpenAnonymous(ciphertext[:10]); err == nil {
+		t.Error("opened a truncated ciphertext")
+	}
+}
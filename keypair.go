@@ -42,6 +42,7 @@ var (
 	ErrInvalidKeyType   = fmt.Errorf("invalid key type")
 	ErrInvalidAlgorithm = fmt.Errorf("invalid key algorithm")
 	ErrChecksumMismatch = fmt.Errorf("checksum mismatch")
+	ErrKeyLength        = fmt.Errorf("key length is invalid")
 )
 
 type PublicKey struct {
@@ -100,6 +101,10 @@ func (kp KeyPair) KeyType() string {
 
 // NewPublicKey generate a PublicKey struct from a key byte
 func NewPublicKey(keyByte []byte) (*PublicKey, error) {
+	if len(keyByte) <= checksumLength {
+		return nil, ErrKeyLength
+	}
+
 	checksumStart := len(keyByte) - checksumLength
 	keyLeft := keyByte[:checksumStart]
 
@@ -121,6 +126,9 @@ func NewPublicKey(keyByte []byte) (*PublicKey, error) {
 	variant = variant >> 4
 	switch variant & 0x01 {
 	case variantKeyTypeED25519:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, ErrKeyLength
+		}
 		ai = &account.ED25519Account{
 			PublicKey: key,
 			Test:      test,
@@ -51,3 +51,56 @@ func TestKIFEncodeDecode(t *testing.T) {
 		p1.PrivateKey.PrivateKeyBytes(),
 		p2.PrivateKey.PrivateKeyBytes()))
 }
+
+func FuzzNewPublicKey(f *testing.F) {
+	f.Add(util.FromBase58("fqN6WnjUaekfrqBvvmsjVskoqXnhJ632xJPHzdSgReC6bhZGuP"))
+	f.Add([]byte{})
+	f.Add([]byte{0x11, 0x00, 0x00, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, keyBytes []byte) {
+		pubKey, err := NewPublicKey(keyBytes)
+		if err != nil {
+			return
+		}
+		assert.Len(t, pubKey.PublicKeyBytes(), 32)
+	})
+}
+
+func FuzzNewKeyPairFromKIF(f *testing.F) {
+	f.Add("cYK2SzQnYLG55yiRCSryymEw3EaNYnCD2mtCwkVXdFLSzQ4ReV")
+	f.Add("")
+	f.Add("0OIl")
+
+	f.Fuzz(func(t *testing.T, kif string) {
+		kp, err := NewKeyPairFromKIF(kif)
+		if err != nil {
+			return
+		}
+		assert.Len(t, kp.PrivateKeyBytes(), 64)
+	})
+}
+
+func FuzzKIFRoundTrip(f *testing.F) {
+	f.Add(util.FromBase58("8VNLU6LSMjnCfMNHG9YftLV1TVWzAphfCSwJsf351974"), true)
+	f.Add(make([]byte, 32), false)
+
+	f.Fuzz(func(t *testing.T, seed []byte, test bool) {
+		if len(seed) != seedLength {
+			t.Skip()
+		}
+
+		p1, err := NewKeyPairFromSeed(seed, test, ED25519)
+		assert.NoError(t, err)
+		kif, err := p1.KIF()
+		assert.NoError(t, err)
+
+		p2, err := NewKeyPairFromKIF(kif)
+		assert.NoError(t, err)
+		assert.Equal(t, test, p2.PrivateKey.IsTesting())
+		assert.Equal(t, p1.PrivateKeyBytes(), p2.PrivateKeyBytes())
+
+		kif2, err := p2.KIF()
+		assert.NoError(t, err)
+		assert.Equal(t, kif, kif2)
+	})
+}
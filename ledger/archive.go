@@ -0,0 +1,372 @@
+package ledger
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	ArchiveVersion = 1
+
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+	assetName     = "asset.json"
+
+	// archives with a file larger than maxArchiveFileSize, more files
+	// than maxArchiveFiles, or more than maxArchiveSize in all are
+	// refused before anything in them is checked
+	maxArchiveFileSize = 1 << 20
+	maxArchiveFiles    = 10000
+	maxArchiveSize     = 64 << 20
+)
+
+var (
+	ErrArchiveFormat    = errors.New("invalid provenance archive")
+	ErrArchiveSignature = errors.New("invalid provenance archive signature")
+	ErrArchiveDigest    = errors.New("provenance archive file does not match its manifest")
+	ErrArchiveChain     = errors.New("broken provenance chain")
+	ErrSessionSender    = errors.New("session data not signed by an owner of the bitmark")
+)
+
+// Manifest describes a provenance archive and lists the SHA3-256
+// digests of its other files. It is stored as manifest.json, with the
+// hex signature of the exporter over it in manifest.sig.
+type Manifest struct {
+	Version   int            `json:"version"`
+	BitmarkId string         `json:"bitmarkId"`
+	AssetId   string         `json:"assetId"`
+	HeadId    string         `json:"headId"`
+	Exporter  string         `json:"exporter"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of a provenance archive
+type ManifestFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	SHA3 string `json:"sha3_256"`
+}
+
+// SessionGrant is session data of the asset file of a bitmark, given by
+// an owner of the bitmark to the recipient
+type SessionGrant struct {
+	Sender    string                  `json:"sender"`
+	Recipient string                  `json:"recipient"`
+	Data      *bitmarklib.SessionData `json:"data"`
+}
+
+// Archive is the complete history of a bitmark read from a provenance
+// archive. Transfers are the oldest first.
+type Archive struct {
+	Manifest  *Manifest
+	Asset     *bitmarklib.Asset
+	Issue     *bitmarklib.Issue
+	Transfers []*bitmarklib.Transfer
+	Sessions  []SessionGrant
+}
+
+// Export writes the asset, issue and transfers of a bitmark, and the
+// session data given, as a tar archive whose manifest is signed by the
+// exporter. The archive can be checked by ImportArchive without the
+// ledger or the network.
+func (l *Ledger) Export(w io.Writer, bitmarkId string, exporter bitmarklib.AuthKey, sessions []SessionGrant) error {
+	provenance, err := l.Provenance(bitmarkId)
+	if err != nil {
+		return err
+	}
+	issue := provenance[len(provenance)-1]
+
+	owners := make(map[string]bool)
+	for _, t := range provenance {
+		owners[t.Owner] = true
+	}
+	for _, s := range sessions {
+		if err := verifySession(s, owners); err != nil {
+			return err
+		}
+	}
+
+	var asset []byte
+	err = l.db.View(func(tx *bolt.Tx) error {
+		asset = tx.Bucket(assetsBucket).Get([]byte(issue.AssetId))
+		if asset == nil {
+			return ErrNotFound
+		}
+		asset = append([]byte{}, asset...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := []string{assetName}
+	files := map[string][]byte{assetName: asset}
+	for n := range provenance {
+		t := provenance[len(provenance)-1-n]
+		name := fmt.Sprintf("txs/%04d-%s.json", n, t.TxId)
+		names = append(names, name)
+		files[name] = t.Record
+	}
+	for n, s := range sessions {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("sessions/%04d.json", n)
+		names = append(names, name)
+		files[name] = data
+	}
+
+	manifest := &Manifest{
+		Version:   ArchiveVersion,
+		BitmarkId: bitmarkId,
+		AssetId:   issue.AssetId,
+		HeadId:    provenance[0].TxId,
+		Exporter:  exporter.AccountNumber(),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, name := range names {
+		digest := sha3.Sum256(files[name])
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name: name,
+			Size: int64(len(files[name])),
+			SHA3: hex.EncodeToString(digest[:]),
+		})
+	}
+
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files[manifestName] = m
+	files[signatureName] = []byte(hex.EncodeToString(exporter.Sign(m)) + "\n")
+
+	tw := tar.NewWriter(w)
+	for _, name := range append([]string{manifestName, signatureName}, names...) {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: manifest.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ImportArchive reads a provenance archive written by Export. It checks
+// the signature of the manifest, the digests of the files, the
+// signature of every record, that each transfer follows the one before
+// it and is signed by the owner, and that session data was given by an
+// owner. The Recipient of a SessionGrant is not signed by the owner, so
+// it is only a label vouched for by the exporter: the session key can
+// only be decrypted by whoever it was encrypted to.
+func ImportArchive(r io.Reader) (*Archive, error) {
+	files, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := files[manifestName]
+	if !ok {
+		return nil, ErrArchiveFormat
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(m, &manifest); err != nil {
+		return nil, ErrArchiveFormat
+	}
+	if manifest.Version != ArchiveVersion {
+		return nil, ErrArchiveFormat
+	}
+
+	exporter, err := account.AccountFromBase58(manifest.Exporter)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(strings.TrimSpace(string(files[signatureName])))
+	if err != nil || !verifySignature(exporter, m, signature) {
+		return nil, ErrArchiveSignature
+	}
+
+	// every file is listed in the manifest, and nothing else is there
+	if len(files) != len(manifest.Files)+2 {
+		return nil, ErrArchiveDigest
+	}
+	listed := make(map[string]bool)
+	for _, f := range manifest.Files {
+		data, ok := files[f.Name]
+		digest := sha3.Sum256(data)
+		known := f.Name == assetName || strings.HasPrefix(f.Name, "txs/") || strings.HasPrefix(f.Name, "sessions/")
+		if !ok || !known || listed[f.Name] || int64(len(data)) != f.Size || hex.EncodeToString(digest[:]) != f.SHA3 {
+			return nil, ErrArchiveDigest
+		}
+		listed[f.Name] = true
+	}
+
+	a := &Archive{Manifest: &manifest}
+	if err := a.readRecords(files); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// readArchive returns the regular files of a tar archive by name
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	var size int64
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		size += h.Size
+		if h.Typeflag != tar.TypeReg || h.Size > maxArchiveFileSize || size > maxArchiveSize || len(files) >= maxArchiveFiles {
+			return nil, ErrArchiveFormat
+		}
+		if _, ok := files[h.Name]; ok {
+			return nil, ErrArchiveFormat
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, maxArchiveFileSize))
+		if err != nil {
+			return nil, err
+		}
+		files[h.Name] = data
+	}
+}
+
+// readRecords decodes and checks the records listed in the manifest
+func (a *Archive) readRecords(files map[string][]byte) error {
+	var asset bitmarklib.Asset
+	if err := json.Unmarshal(files[assetName], &asset); err != nil {
+		return ErrArchiveFormat
+	}
+	if _, err := asset.Pack(asset.Registrant); err != nil {
+		return err
+	}
+	assetId, _ := asset.AssetId().MarshalText()
+	if string(assetId) != a.Manifest.AssetId {
+		return ErrArchiveChain
+	}
+	a.Asset = &asset
+
+	owners := make(map[string]bool)
+	var head string
+	var owner *account.Account
+	for _, f := range a.Manifest.Files {
+		if !strings.HasPrefix(f.Name, "txs/") {
+			continue
+		}
+
+		if a.Issue == nil {
+			var issue bitmarklib.Issue
+			if err := json.Unmarshal(files[f.Name], &issue); err != nil {
+				return ErrArchiveFormat
+			}
+			txId, err := issue.TxId()
+			if err != nil {
+				return err
+			}
+			if txId != a.Manifest.BitmarkId || issue.AssetId != asset.AssetId() {
+				return ErrArchiveChain
+			}
+			a.Issue = &issue
+			head, owner = txId, issue.Owner
+		} else {
+			var transfer bitmarklib.Transfer
+			if err := json.Unmarshal(files[f.Name], &transfer); err != nil || transfer.BitmarkTransferUnratified == nil {
+				return ErrArchiveFormat
+			}
+			link, _ := transfer.Link.MarshalText()
+			if string(link) != head {
+				return ErrArchiveChain
+			}
+			if _, err := transfer.Pack(owner); err != nil {
+				return err
+			}
+			txId, err := transfer.TxId()
+			if err != nil {
+				return err
+			}
+			a.Transfers = append(a.Transfers, &transfer)
+			head, owner = txId, transfer.Owner
+		}
+		owners[owner.String()] = true
+	}
+	if a.Issue == nil || head != a.Manifest.HeadId {
+		return ErrArchiveChain
+	}
+
+	for _, f := range a.Manifest.Files {
+		if !strings.HasPrefix(f.Name, "sessions/") {
+			continue
+		}
+		var s SessionGrant
+		if err := json.Unmarshal(files[f.Name], &s); err != nil {
+			return ErrArchiveFormat
+		}
+		if err := verifySession(s, owners); err != nil {
+			return err
+		}
+		a.Sessions = append(a.Sessions, s)
+	}
+	return nil
+}
+
+// verifySession checks that session data is signed by one of the owners
+func verifySession(s SessionGrant, owners map[string]bool) error {
+	if s.Data == nil || !owners[s.Sender] {
+		return ErrSessionSender
+	}
+	if _, err := account.AccountFromBase58(s.Recipient); err != nil {
+		return err
+	}
+	sender, err := account.AccountFromBase58(s.Sender)
+	if err != nil {
+		return err
+	}
+	return s.Data.Verify(sender.PublicKeyBytes())
+}
+
+func verifySignature(acc *account.Account, message, signature []byte) bool {
+	publicKey := acc.PublicKeyBytes()
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, message, signature)
+}
+
+// AddArchive adds the records of an archive read by ImportArchive
+func (l *Ledger) AddArchive(a *Archive) error {
+	if _, err := l.AddAsset(a.Asset); err != nil {
+		return err
+	}
+	if _, err := l.AddIssue(a.Issue); err != nil {
+		return err
+	}
+	for _, t := range a.Transfers {
+		if _, err := l.AddTransfer(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
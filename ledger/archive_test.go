@@ -0,0 +1,240 @@
+package ledger
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/sha3"
+)
+
+func key32(b []byte) *[32]byte {
+	var k [32]byte
+	copy(k[:], b)
+	return &k
+}
+
+// history returns a ledger with a bitmark issued by alice and transferred
+// to bob then carol, and session data from alice to bob
+func history(t *testing.T) (*Ledger, string, []*bitmarklib.AccountKeys, SessionGrant) {
+	alice := mustAccountKeys(t, "5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	bob := mustAccountKeys(t, "5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	carol := mustAccountKeys(t, "5XEECqbX3HpUum7DiRNTRuqWkg8NrFvFDM8GLpckebep6cDgM5eHqzd")
+
+	l := open(t, filepath.Join(t.TempDir(), "ledger.db"))
+	t.Cleanup(func() { l.Close() })
+
+	asset := bitmarklib.NewAsset("archive", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(alice.AuthKey))
+	_, err := l.AddAsset(&asset)
+	assert.NoError(t, err)
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(alice.AuthKey))
+	bitmarkId, err := l.AddIssue(&issue)
+	assert.NoError(t, err)
+	_, err = l.AddTransfer(transfer(t, l, bitmarkId, alice, bob))
+	assert.NoError(t, err)
+	_, err = l.AddTransfer(transfer(t, l, bitmarkId, bob, carol))
+	assert.NoError(t, err)
+
+	sessKey, _ := bitmarklib.NewChaCha20SessionKey()
+	data, err := bitmarklib.CreateSessionData(sessKey, key32(bob.EncrKey.PublicKeyBytes()), key32(alice.EncrKey.PrivateKeyBytes()), alice.AuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	grant := SessionGrant{
+		Sender:    alice.AuthKey.AccountNumber(),
+		Recipient: bob.AuthKey.AccountNumber(),
+		Data:      data,
+	}
+
+	return l, bitmarkId, []*bitmarklib.AccountKeys{alice, bob, carol}, grant
+}
+
+// rewrite changes the files of an archive, then signs its manifest
+// again with signer, if given
+func rewrite(t *testing.T, archive []byte, signer bitmarklib.AuthKey, change func(files map[string][]byte, manifest *Manifest)) []byte {
+	files, err := readArchive(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	assert.NoError(t, json.Unmarshal(files[manifestName], &manifest))
+	before, _ := json.Marshal(manifest)
+	change(files, &manifest)
+
+	if after, _ := json.Marshal(manifest); signer == nil && !bytes.Equal(before, after) {
+		files[manifestName] = after
+	}
+	if signer != nil {
+		for n, f := range manifest.Files {
+			digest := sha3.Sum256(files[f.Name])
+			manifest.Files[n].Size = int64(len(files[f.Name]))
+			manifest.Files[n].SHA3 = hex.EncodeToString(digest[:])
+		}
+		manifest.Exporter = signer.AccountNumber()
+		files[manifestName], _ = json.Marshal(manifest)
+		files[signatureName] = []byte(hex.EncodeToString(signer.Sign(files[manifestName])))
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	l, bitmarkId, keys, grant := history(t)
+	alice, bob, carol := keys[0], keys[1], keys[2]
+
+	var buf bytes.Buffer
+	assert.NoError(t, l.Export(&buf, bitmarkId, carol.AuthKey, []SessionGrant{grant}))
+
+	// the manifest comes first, so it can be read with plain tar
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	h, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, manifestName, h.Name)
+	m, _ := ioutil.ReadAll(tr)
+	assert.Contains(t, string(m), `"exporter": "`+carol.AuthKey.AccountNumber()+`"`)
+
+	a, err := ImportArchive(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkId, a.Manifest.BitmarkId)
+	assert.Equal(t, "archive", a.Asset.Name)
+	assert.Equal(t, alice.AuthKey.AccountNumber(), a.Issue.Owner.String())
+	if assert.Len(t, a.Transfers, 2) {
+		assert.Equal(t, bob.AuthKey.AccountNumber(), a.Transfers[0].Owner.String())
+		assert.Equal(t, carol.AuthKey.AccountNumber(), a.Transfers[1].Owner.String())
+	}
+	if assert.Len(t, a.Sessions, 1) {
+		assert.Equal(t, grant.Data.EncryptedSessionKey, a.Sessions[0].Data.EncryptedSessionKey)
+	}
+
+	// the archive rebuilds the history in another ledger
+	other := open(t, filepath.Join(t.TempDir(), "other.db"))
+	defer other.Close()
+	assert.NoError(t, other.AddArchive(a))
+	expected, _ := l.Provenance(bitmarkId)
+	provenance, err := other.Provenance(bitmarkId)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, provenance)
+
+	_, err = ImportArchive(bytes.NewReader(buf.Bytes()[:1024]))
+	assert.Error(t, err)
+
+	// too many files are refused before the manifest is read
+	var many bytes.Buffer
+	tw := tar.NewWriter(&many)
+	for n := 0; n <= maxArchiveFiles; n++ {
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("txs/%d.json", n), Mode: 0644})
+	}
+	tw.Close()
+	_, err = ImportArchive(bytes.NewReader(many.Bytes()))
+	assert.Equal(t, ErrArchiveFormat, err)
+
+	assert.Equal(t, ErrNotFound, l.Export(&bytes.Buffer{}, "missing", carol.AuthKey, nil))
+}
+
+func TestArchiveExportSessions(t *testing.T) {
+	l, bitmarkId, keys, grant := history(t)
+	outsider := mustAccountKeys(t, "5XEECt18HGBGNET1PpxLhy5CsCLG9jnmM6Q8QGF4U2yGb1DABXZsVeD")
+
+	grant.Sender = outsider.AuthKey.AccountNumber()
+	assert.Equal(t, ErrSessionSender, l.Export(&bytes.Buffer{}, bitmarkId, keys[0].AuthKey, []SessionGrant{grant}))
+
+	grant.Sender = keys[1].AuthKey.AccountNumber()
+	assert.Error(t, l.Export(&bytes.Buffer{}, bitmarkId, keys[0].AuthKey, []SessionGrant{grant}))
+}
+
+func TestArchiveTampered(t *testing.T) {
+	l, bitmarkId, keys, grant := history(t)
+	alice, bob := keys[0], keys[1]
+
+	var buf bytes.Buffer
+	assert.NoError(t, l.Export(&buf, bitmarkId, alice.AuthKey, []SessionGrant{grant}))
+	archive := buf.Bytes()
+
+	cases := map[string]struct {
+		signer bitmarklib.AuthKey
+		change func(files map[string][]byte, manifest *Manifest)
+		err    error
+	}{
+		"file changed": {
+			change: func(files map[string][]byte, _ *Manifest) {
+				files[assetName] = bytes.Replace(files[assetName], []byte("archive"), []byte("forged!"), 1)
+			},
+			err: ErrArchiveDigest,
+		},
+		"file added": {
+			change: func(files map[string][]byte, _ *Manifest) {
+				files["extra.json"] = []byte("{}")
+			},
+			err: ErrArchiveDigest,
+		},
+		"file removed": {
+			change: func(files map[string][]byte, _ *Manifest) {
+				delete(files, "sessions/0000.json")
+			},
+			err: ErrArchiveDigest,
+		},
+		"manifest changed": {
+			change: func(_ map[string][]byte, m *Manifest) {
+				m.Exporter = bob.AuthKey.AccountNumber()
+			},
+			err: ErrArchiveSignature,
+		},
+		"last transfer dropped": {
+			signer: bob.AuthKey,
+			change: func(files map[string][]byte, m *Manifest) {
+				last := m.Files[len(m.Files)-2]
+				delete(files, last.Name)
+				m.Files = append(m.Files[:len(m.Files)-2], m.Files[len(m.Files)-1])
+			},
+			err: ErrArchiveChain,
+		},
+		"transfers reordered": {
+			signer: bob.AuthKey,
+			change: func(files map[string][]byte, m *Manifest) {
+				m.Files[2], m.Files[3] = m.Files[3], m.Files[2]
+			},
+			err: ErrArchiveChain,
+		},
+		"record replaced": {
+			signer: bob.AuthKey,
+			change: func(files map[string][]byte, m *Manifest) {
+				var transfer bitmarklib.Transfer
+				name := m.Files[3].Name
+				json.Unmarshal(files[name], &transfer)
+				transfer.Owner = bob.AuthKey.PublicKey()
+				files[name], _ = json.Marshal(transfer)
+			},
+		},
+		"session forged": {
+			signer: bob.AuthKey,
+			change: func(files map[string][]byte, _ *Manifest) {
+				g := grant
+				g.Sender = bob.AuthKey.AccountNumber()
+				files["sessions/0000.json"], _ = json.Marshal(g)
+			},
+		},
+	}
+
+	for name, c := range cases {
+		_, err := ImportArchive(bytes.NewReader(rewrite(t, archive, c.signer, c.change)))
+		if c.err != nil {
+			assert.Equal(t, c.err, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Package ledger keeps a local index of signed records, so that a
+// service can answer ownership and provenance questions about its own
+// bitmarks without asking the API
+package ledger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrNotFound     = errors.New("not found in ledger")
+	ErrUnknownAsset = errors.New("issue of an asset not in ledger")
+	ErrUnknownLink  = errors.New("transfer of a transaction not in ledger")
+	ErrNotHead      = errors.New("transfer of a transaction that is already transferred")
+
+	assetsBucket   = []byte("assets")
+	txsBucket      = []byte("txs")
+	headsBucket    = []byte("heads")
+	ownersBucket   = []byte("owners")
+	editionsBucket = []byte("editions")
+)
+
+// Tx is an issue or a transfer in the ledger. Record is the signed
+// record as JSON, and PreviousId is empty for an issue.
+type Tx struct {
+	TxId       string          `json:"txId"`
+	BitmarkId  string          `json:"bitmarkId"`
+	AssetId    string          `json:"assetId"`
+	Owner      string          `json:"owner"`
+	PreviousId string          `json:"previousId,omitempty"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// Ledger indexes assets, issues and transfers in a bbolt database.
+// Records are checked before they are added: their signatures, that the
+// asset of an issue is known, and that a transfer is signed by the owner
+// of the bitmark and follows its head.
+type Ledger struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the ledger database at path
+func Open(path string) (*Ledger, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{assetsBucket, txsBucket, headsBucket, ownersBucket, editionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Ledger{db: db}, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// AddAsset adds a signed asset and returns its id. Adding an asset that
+// is already in the ledger does nothing.
+func (l *Ledger) AddAsset(asset *bitmarklib.Asset) (string, error) {
+	if _, err := asset.Pack(asset.Registrant); err != nil {
+		return "", err
+	}
+	assetId, err := asset.AssetId().MarshalText()
+	if err != nil {
+		return "", err
+	}
+	record, err := json.Marshal(asset)
+	if err != nil {
+		return "", err
+	}
+
+	return string(assetId), l.db.Update(func(tx *bolt.Tx) error {
+		assets := tx.Bucket(assetsBucket)
+		if assets.Get(assetId) != nil {
+			return nil
+		}
+		return assets.Put(assetId, record)
+	})
+}
+
+// AddIssue adds a signed issue of an asset in the ledger and returns
+// its txid, which is the id of the bitmark
+func (l *Ledger) AddIssue(issue *bitmarklib.Issue) (string, error) {
+	txId, err := issue.TxId()
+	if err != nil {
+		return "", err
+	}
+	assetId, err := issue.AssetId.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	record, err := json.Marshal(issue)
+	if err != nil {
+		return "", err
+	}
+
+	t := &Tx{
+		TxId:      txId,
+		BitmarkId: txId,
+		AssetId:   string(assetId),
+		Owner:     issue.Owner.String(),
+		Record:    record,
+	}
+	return txId, l.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(txsBucket).Get([]byte(txId)) != nil {
+			return nil
+		}
+		if tx.Bucket(assetsBucket).Get(assetId) == nil {
+			return ErrUnknownAsset
+		}
+
+		editions := tx.Bucket(editionsBucket)
+		seq, err := editions.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := editions.Put(editionKey(t.AssetId, seq), []byte(txId)); err != nil {
+			return err
+		}
+		return putTx(tx, t)
+	})
+}
+
+// AddTransfer adds a transfer of the head of a bitmark in the ledger,
+// signed by its owner, and returns its txid
+func (l *Ledger) AddTransfer(transfer *bitmarklib.Transfer) (string, error) {
+	txId, err := transfer.TxId()
+	if err != nil {
+		return "", err
+	}
+	previousId, err := transfer.Link.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	record, err := json.Marshal(transfer)
+	if err != nil {
+		return "", err
+	}
+
+	return txId, l.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(txsBucket).Get([]byte(txId)) != nil {
+			return nil
+		}
+
+		previous, err := getTx(tx, string(previousId))
+		if err == ErrNotFound {
+			return ErrUnknownLink
+		} else if err != nil {
+			return err
+		}
+		if head := tx.Bucket(headsBucket).Get([]byte(previous.BitmarkId)); string(head) != previous.TxId {
+			return ErrNotHead
+		}
+
+		owner, err := account.AccountFromBase58(previous.Owner)
+		if err != nil {
+			return err
+		}
+		if _, err := transfer.Pack(owner); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(ownersBucket).Delete(ownerKey(previous.Owner, previous.BitmarkId)); err != nil {
+			return err
+		}
+		return putTx(tx, &Tx{
+			TxId:       txId,
+			BitmarkId:  previous.BitmarkId,
+			AssetId:    previous.AssetId,
+			Owner:      transfer.Owner.String(),
+			PreviousId: previous.TxId,
+			Record:     record,
+		})
+	})
+}
+
+// putTx stores a transaction and makes it the head of its bitmark
+func putTx(tx *bolt.Tx, t *Tx) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(txsBucket).Put([]byte(t.TxId), data); err != nil {
+		return err
+	}
+	if err := tx.Bucket(headsBucket).Put([]byte(t.BitmarkId), []byte(t.TxId)); err != nil {
+		return err
+	}
+	return tx.Bucket(ownersBucket).Put(ownerKey(t.Owner, t.BitmarkId), []byte{})
+}
+
+func getTx(tx *bolt.Tx, txId string) (*Tx, error) {
+	data := tx.Bucket(txsBucket).Get([]byte(txId))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var t Tx
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Asset returns the asset of the id
+func (l *Ledger) Asset(assetId string) (*bitmarklib.Asset, error) {
+	var asset bitmarklib.Asset
+	err := l.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(assetsBucket).Get([]byte(assetId))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &asset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// Tx returns the issue or transfer of the txid
+func (l *Ledger) Tx(txId string) (*Tx, error) {
+	var t *Tx
+	err := l.db.View(func(tx *bolt.Tx) error {
+		var err error
+		t, err = getTx(tx, txId)
+		return err
+	})
+	return t, err
+}
+
+// Head returns the txid of the last transaction of a bitmark, which the
+// next transfer of the bitmark links to
+func (l *Ledger) Head(bitmarkId string) (string, error) {
+	var head string
+	err := l.db.View(func(tx *bolt.Tx) error {
+		h := tx.Bucket(headsBucket).Get([]byte(bitmarkId))
+		if h == nil {
+			return ErrNotFound
+		}
+		head = string(h)
+		return nil
+	})
+	return head, err
+}
+
+// NewTransfer returns an unsigned transfer of the head of a bitmark to
+// the new owner
+func (l *Ledger) NewTransfer(bitmarkId, newOwner string) (*bitmarklib.Transfer, error) {
+	head, err := l.Head(bitmarkId)
+	if err != nil {
+		return nil, err
+	}
+	return bitmarklib.NewTransfer(head, newOwner)
+}
+
+// Provenance returns the transactions of a bitmark, from its head back
+// to its issue
+func (l *Ledger) Provenance(bitmarkId string) ([]*Tx, error) {
+	var txs []*Tx
+	err := l.db.View(func(tx *bolt.Tx) error {
+		h := tx.Bucket(headsBucket).Get([]byte(bitmarkId))
+		if h == nil {
+			return ErrNotFound
+		}
+		for txId := string(h); txId != ""; {
+			t, err := getTx(tx, txId)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, t)
+			txId = t.PreviousId
+		}
+		return nil
+	})
+	return txs, err
+}
+
+// Owned returns the ids of the bitmarks owned by an account
+func (l *Ledger) Owned(accountNumber string) ([]string, error) {
+	var bitmarkIds []string
+	err := l.db.View(func(tx *bolt.Tx) error {
+		prefix := ownerKey(accountNumber, "")
+		c := tx.Bucket(ownersBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			bitmarkIds = append(bitmarkIds, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return bitmarkIds, err
+}
+
+// Editions returns the ids of the bitmarks issued of an asset, in the
+// order they were added
+func (l *Ledger) Editions(assetId string) ([]string, error) {
+	var bitmarkIds []string
+	err := l.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(assetId + "/")
+		c := tx.Bucket(editionsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			bitmarkIds = append(bitmarkIds, string(v))
+		}
+		return nil
+	})
+	return bitmarkIds, err
+}
+
+// account numbers and ids are base58 and hex, which never contain the
+// separator
+func ownerKey(accountNumber, bitmarkId string) []byte {
+	return []byte(accountNumber + "/" + bitmarkId)
+}
+
+func editionKey(assetId string, seq uint64) []byte {
+	key := make([]byte, len(assetId)+1+8)
+	copy(key, assetId+"/")
+	binary.BigEndian.PutUint64(key[len(assetId)+1:], seq)
+	return key
+}
@@ -0,0 +1,197 @@
+package ledger
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustAccountKeys(t *testing.T, seedStr string) *bitmarklib.AccountKeys {
+	seed, err := bitmarklib.SeedFromBase58(seedStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func open(t *testing.T, path string) *Ledger {
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func transfer(t *testing.T, l *Ledger, bitmarkId string, from, to *bitmarklib.AccountKeys) *bitmarklib.Transfer {
+	tr, err := l.NewTransfer(bitmarkId, to.AuthKey.AccountNumber())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, tr.ClaimedBy(from.AuthKey))
+	return tr
+}
+
+func TestLedger(t *testing.T) {
+	alice := mustAccountKeys(t, "5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	bob := mustAccountKeys(t, "5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	carol := mustAccountKeys(t, "5XEECqbX3HpUum7DiRNTRuqWkg8NrFvFDM8GLpckebep6cDgM5eHqzd")
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	l := open(t, path)
+
+	asset := bitmarklib.NewAsset("ledger", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(alice.AuthKey))
+	assetId, err := l.AddAsset(&asset)
+	assert.NoError(t, err)
+
+	var bitmarkIds []string
+	for n := 0; n < 2; n++ {
+		issue := bitmarklib.NewIssue(asset.AssetId())
+		assert.NoError(t, issue.ClaimedBy(alice.AuthKey))
+		bitmarkId, err := l.AddIssue(&issue)
+		assert.NoError(t, err)
+		bitmarkIds = append(bitmarkIds, bitmarkId)
+
+		// adding a record again does nothing
+		again, err := l.AddIssue(&issue)
+		assert.NoError(t, err)
+		assert.Equal(t, bitmarkId, again)
+	}
+	first := bitmarkIds[0]
+
+	toBob := transfer(t, l, first, alice, bob)
+	toBobId, err := l.AddTransfer(toBob)
+	assert.NoError(t, err)
+	toCarolId, err := l.AddTransfer(transfer(t, l, first, bob, carol))
+	assert.NoError(t, err)
+
+	// the records survive a restart
+	assert.NoError(t, l.Close())
+	l = open(t, path)
+	defer l.Close()
+
+	head, err := l.Head(first)
+	assert.NoError(t, err)
+	assert.Equal(t, toCarolId, head)
+
+	provenance, err := l.Provenance(first)
+	assert.NoError(t, err)
+	if assert.Len(t, provenance, 3) {
+		assert.Equal(t, toCarolId, provenance[0].TxId)
+		assert.Equal(t, carol.AuthKey.AccountNumber(), provenance[0].Owner)
+		assert.Equal(t, toBobId, provenance[1].TxId)
+		assert.Equal(t, first, provenance[1].PreviousId)
+		assert.Equal(t, first, provenance[2].TxId)
+		assert.Equal(t, "", provenance[2].PreviousId)
+		assert.Equal(t, alice.AuthKey.AccountNumber(), provenance[2].Owner)
+		assert.Equal(t, assetId, provenance[2].AssetId)
+	}
+
+	editions, err := l.Editions(assetId)
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkIds, editions)
+
+	owned, err := l.Owned(alice.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkIds[1:], owned)
+	owned, _ = l.Owned(bob.AuthKey.AccountNumber())
+	assert.Empty(t, owned)
+	owned, _ = l.Owned(carol.AuthKey.AccountNumber())
+	assert.Equal(t, []string{first}, owned)
+
+	a, err := l.Asset(assetId)
+	assert.NoError(t, err)
+	assert.Equal(t, "ledger", a.Name)
+	assert.Equal(t, asset.AssetId(), a.AssetId())
+
+	tx, err := l.Tx(toBobId)
+	assert.NoError(t, err)
+	assert.Equal(t, first, tx.BitmarkId)
+
+	_, err = l.Tx("missing")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = l.Head("missing")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = l.Provenance("missing")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = l.Asset("missing")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestLedgerRejects(t *testing.T) {
+	alice := mustAccountKeys(t, "5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	bob := mustAccountKeys(t, "5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	l := open(t, filepath.Join(t.TempDir(), "ledger.db"))
+	defer l.Close()
+
+	asset := bitmarklib.NewAsset("ledger", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(alice.AuthKey))
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(alice.AuthKey))
+
+	_, err := l.AddIssue(&issue)
+	assert.Equal(t, ErrUnknownAsset, err)
+
+	forged := asset
+	forged.Name = "forged"
+	_, err = l.AddAsset(&forged)
+	assert.Error(t, err)
+
+	_, err = l.AddAsset(&asset)
+	assert.NoError(t, err)
+	bitmarkId, err := l.AddIssue(&issue)
+	assert.NoError(t, err)
+
+	// only the owner can transfer a bitmark
+	_, err = l.AddTransfer(transfer(t, l, bitmarkId, bob, bob))
+	assert.Error(t, err)
+
+	unknown, _ := bitmarklib.NewTransfer("8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7", bob.AuthKey.AccountNumber())
+	assert.NoError(t, unknown.ClaimedBy(alice.AuthKey))
+	_, err = l.AddTransfer(unknown)
+	assert.Equal(t, ErrUnknownLink, err)
+
+	// a bitmark can not be transferred twice from the same transaction
+	toBob := transfer(t, l, bitmarkId, alice, bob)
+	toAlice := transfer(t, l, bitmarkId, alice, alice)
+	_, err = l.AddTransfer(toBob)
+	assert.NoError(t, err)
+	_, err = l.AddTransfer(toAlice)
+	assert.Equal(t, ErrNotHead, err)
+
+	owned, _ := l.Owned(bob.AuthKey.AccountNumber())
+	assert.Equal(t, []string{bitmarkId}, owned)
+}
+
+func TestLedgerBurn(t *testing.T) {
+	alice := mustAccountKeys(t, "5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	zero := &account.Account{AccountInterface: &account.ED25519Account{Test: true, PublicKey: make([]byte, 32)}}
+	l := open(t, filepath.Join(t.TempDir(), "ledger.db"))
+	defer l.Close()
+
+	asset := bitmarklib.NewAsset("ledger", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(alice.AuthKey))
+	_, err := l.AddAsset(&asset)
+	assert.NoError(t, err)
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(alice.AuthKey))
+	bitmarkId, err := l.AddIssue(&issue)
+	assert.NoError(t, err)
+
+	burn, err := l.NewTransfer(bitmarkId, zero.String())
+	assert.NoError(t, err)
+	assert.NoError(t, burn.ClaimedBy(alice.AuthKey))
+	burnId, err := l.AddTransfer(burn)
+	assert.NoError(t, err)
+
+	head, _ := l.Head(bitmarkId)
+	assert.Equal(t, burnId, head)
+	owned, _ := l.Owned(alice.AuthKey.AccountNumber())
+	assert.Empty(t, owned)
+}
@@ -0,0 +1,303 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/util"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	messageVersion1 = 1
+	messageIdSize   = 16
+)
+
+var (
+	ErrMessageFormat    = errors.New("invalid message format")
+	ErrMessageSender    = errors.New("message is not from the expected sender")
+	ErrMessageRecipient = errors.New("message is not for this account")
+	ErrMessageExpired   = errors.New("message is outside of the replay window")
+	ErrMessageReplayed  = errors.New("message has already been received")
+)
+
+// Message is a private message between two Bitmark accounts
+type Message struct {
+	Sender    string
+	Recipient string
+	Timestamp time.Time
+	Id        []byte
+	Body      []byte
+}
+
+// pack returns the signed form of the message, which consists of:
+// version (varint)
+// length (varint) and base58 string of the sender's account number
+// length (varint) and base58 string of the recipient's account number
+// timestamp in unix nanoseconds (varint)
+// length (varint) and bytes of the id
+// length (varint) and bytes of the body
+func (m *Message) pack() []byte {
+	var b bytes.Buffer
+	b.Write(util.ToVarint64(messageVersion1))
+	b.Write(util.ToVarint64(uint64(len(m.Sender))))
+	b.WriteString(m.Sender)
+	b.Write(util.ToVarint64(uint64(len(m.Recipient))))
+	b.WriteString(m.Recipient)
+	b.Write(util.ToVarint64(uint64(m.Timestamp.UnixNano())))
+	b.Write(util.ToVarint64(uint64(len(m.Id))))
+	b.Write(m.Id)
+	b.Write(util.ToVarint64(uint64(len(m.Body))))
+	b.Write(m.Body)
+	return b.Bytes()
+}
+
+func unpackMessage(packed []byte) (*Message, error) {
+	nextVarint := func() (uint64, error) {
+		v, n := util.FromVarint64(packed)
+		if n == 0 {
+			return 0, ErrMessageFormat
+		}
+		packed = packed[n:]
+		return v, nil
+	}
+	nextField := func() ([]byte, error) {
+		length, err := nextVarint()
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(len(packed)) {
+			return nil, ErrMessageFormat
+		}
+		field := packed[:length]
+		packed = packed[length:]
+		return field, nil
+	}
+
+	version, err := nextVarint()
+	if err != nil {
+		return nil, err
+	}
+	if version != messageVersion1 {
+		return nil, ErrMessageFormat
+	}
+
+	m := &Message{}
+	sender, err := nextField()
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := nextField()
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := nextVarint()
+	if err != nil {
+		return nil, err
+	}
+	if m.Id, err = nextField(); err != nil {
+		return nil, err
+	}
+	if len(m.Id) != messageIdSize {
+		return nil, ErrMessageFormat
+	}
+	if m.Body, err = nextField(); err != nil {
+		return nil, err
+	}
+	if len(packed) != 0 {
+		return nil, ErrMessageFormat
+	}
+
+	m.Sender = string(sender)
+	m.Recipient = string(recipient)
+	m.Timestamp = time.Unix(0, int64(timestamp))
+	return m, nil
+}
+
+// SealedMessage is a Message signed by the sender's auth key and
+// encrypted to the recipient's encryption key. Only the account numbers
+// are visible, so that the recipient can look up the sender's keys.
+type SealedMessage struct {
+	Sender     string
+	Recipient  string
+	Ciphertext []byte
+}
+
+func (s *SealedMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Sender     string `json:"sender"`
+		Recipient  string `json:"recipient"`
+		Ciphertext string `json:"ciphertext"`
+	}{
+		Sender:     s.Sender,
+		Recipient:  s.Recipient,
+		Ciphertext: hex.EncodeToString(s.Ciphertext),
+	})
+}
+
+func (s *SealedMessage) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Sender     string `json:"sender"`
+		Recipient  string `json:"recipient"`
+		Ciphertext string `json:"ciphertext"`
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	ciphertext, err := hex.DecodeString(aux.Ciphertext)
+	if err != nil {
+		return err
+	}
+
+	s.Sender = aux.Sender
+	s.Recipient = aux.Recipient
+	s.Ciphertext = ciphertext
+	return nil
+}
+
+// Seal signs a message body with the sender's auth key and encrypts it
+// to the recipient, whose announced encryption key is checked first.
+// The account numbers of both ends are signed along with the body, so
+// the recipient cannot pass the message on as if it was sent to others.
+func Seal(body []byte, senderKeys *AccountKeys, recipient *EncryptionKeyAnnouncement) (*SealedMessage, error) {
+	if err := recipient.Verify(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, messageIdSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Sender:    senderKeys.AuthKey.AccountNumber(),
+		Recipient: recipient.Account.String(),
+		Timestamp: time.Now(),
+		Id:        id,
+		Body:      body,
+	}
+
+	packed := m.pack()
+	signed := append(packed, senderKeys.AuthKey.Sign(packed)...)
+
+	ciphertext, err := senderKeys.EncrKey.Encrypt(signed, recipient.EncrPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedMessage{
+		Sender:     m.Sender,
+		Recipient:  m.Recipient,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts a sealed message and checks that it was signed by the
+// sender of the announcement and sent to the owner of recipientKeys.
+// If a ReplayFilter is given, a message outside of its window or seen
+// before is rejected.
+func Open(sealed *SealedMessage, recipientKeys *AccountKeys, sender *EncryptionKeyAnnouncement, filter *ReplayFilter) (*Message, error) {
+	if err := sender.Verify(); err != nil {
+		return nil, err
+	}
+	if sealed.Sender != sender.Account.String() {
+		return nil, ErrMessageSender
+	}
+	if sealed.Recipient != recipientKeys.AuthKey.AccountNumber() {
+		return nil, ErrMessageRecipient
+	}
+
+	signed, err := recipientKeys.EncrKey.Decrypt(sealed.Ciphertext, sender.EncrPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(signed) < ed25519.SignatureSize {
+		return nil, ErrMessageFormat
+	}
+
+	packed := signed[:len(signed)-ed25519.SignatureSize]
+	signature := signed[len(signed)-ed25519.SignatureSize:]
+	if !ed25519.Verify(sender.Account.PublicKeyBytes(), packed, signature) {
+		return nil, errors.New("invalid message signature")
+	}
+
+	m, err := unpackMessage(packed)
+	if err != nil {
+		return nil, err
+	}
+	if m.Sender != sealed.Sender {
+		return nil, ErrMessageSender
+	}
+	if m.Recipient != sealed.Recipient {
+		return nil, ErrMessageRecipient
+	}
+
+	if filter != nil {
+		if err := filter.Check(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// ReplayFilter rejects messages whose timestamp is too far from now and
+// messages whose id has already been seen within the window
+type ReplayFilter struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[replayKey]time.Time
+}
+
+type replayKey struct {
+	sender string
+	id     [messageIdSize]byte
+}
+
+func NewReplayFilter(window time.Duration) *ReplayFilter {
+	return &ReplayFilter{
+		window: window,
+		seen:   make(map[replayKey]time.Time),
+	}
+}
+
+// Check records the id of the message, so the same message is only
+// accepted once
+func (f *ReplayFilter) Check(m *Message) error {
+	if len(m.Id) != messageIdSize {
+		return ErrMessageFormat
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if m.Timestamp.Before(now.Add(-f.window)) || m.Timestamp.After(now.Add(f.window)) {
+		return ErrMessageExpired
+	}
+
+	for id, expiry := range f.seen {
+		if now.After(expiry) {
+			delete(f.seen, id)
+		}
+	}
+
+	id := replayKey{sender: m.Sender}
+	copy(id.id[:], m.Id)
+	if _, ok := f.seen[id]; ok {
+		return ErrMessageReplayed
+	}
+
+	// an id must be kept for as long as its timestamp is accepted
+	f.seen[id] = m.Timestamp.Add(f.window)
+	return nil
+}
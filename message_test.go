@@ -0,0 +1,87 @@
+package bitmarklib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustAccountKeys(seedStr string) *AccountKeys {
+	seed, err := SeedFromBase58(seedStr)
+	if err != nil {
+		panic(err)
+	}
+	keys, err := NewAccountKeys(seed)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+func TestMessage(t *testing.T) {
+	seller := mustAccountKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	buyer := mustAccountKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	sealed, err := Seal([]byte("offer: 10 BTC"), seller, NewEncryptionKeyAnnouncement(buyer))
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(sealed)
+	assert.NoError(t, err)
+	var received SealedMessage
+	assert.NoError(t, json.Unmarshal(data, &received))
+
+	filter := NewReplayFilter(time.Minute)
+	m, err := Open(&received, buyer, NewEncryptionKeyAnnouncement(seller), filter)
+	assert.NoError(t, err)
+	assert.Equal(t, "offer: 10 BTC", string(m.Body))
+	assert.Equal(t, seller.AuthKey.AccountNumber(), m.Sender)
+	assert.Equal(t, buyer.AuthKey.AccountNumber(), m.Recipient)
+	assert.Len(t, m.Id, messageIdSize)
+
+	_, err = Open(&received, buyer, NewEncryptionKeyAnnouncement(seller), filter)
+	assert.Equal(t, ErrMessageReplayed, err)
+
+	// opened by the wrong recipient
+	_, err = Open(&received, seller, NewEncryptionKeyAnnouncement(seller), nil)
+	assert.Equal(t, ErrMessageRecipient, err)
+
+	// claimed to be from someone else
+	_, err = Open(&received, buyer, NewEncryptionKeyAnnouncement(buyer), nil)
+	assert.Equal(t, ErrMessageSender, err)
+
+	tampered := received
+	tampered.Ciphertext = append([]byte{}, received.Ciphertext...)
+	tampered.Ciphertext[30] ^= 0x01
+	_, err = Open(&tampered, buyer, NewEncryptionKeyAnnouncement(seller), nil)
+	assert.Error(t, err)
+}
+
+func TestReplayFilterWindow(t *testing.T) {
+	filter := NewReplayFilter(time.Minute)
+
+	id := func(b byte) []byte {
+		id := make([]byte, messageIdSize)
+		id[0] = b
+		return id
+	}
+
+	assert.Equal(t, ErrMessageExpired, filter.Check(&Message{Id: id(1), Timestamp: time.Now().Add(-2 * time.Minute)}))
+	assert.Equal(t, ErrMessageExpired, filter.Check(&Message{Id: id(2), Timestamp: time.Now().Add(2 * time.Minute)}))
+	assert.NoError(t, filter.Check(&Message{Id: id(3), Timestamp: time.Now()}))
+	assert.NoError(t, filter.Check(&Message{Sender: "other", Id: id(3), Timestamp: time.Now()}))
+
+	assert.Equal(t, ErrMessageFormat, filter.Check(&Message{Id: []byte{4}, Timestamp: time.Now()}))
+}
+
+func TestUnpackMessageId(t *testing.T) {
+	m := &Message{Sender: "a", Recipient: "b", Timestamp: time.Now(), Id: make([]byte, messageIdSize)}
+	_, err := unpackMessage(m.pack())
+	assert.NoError(t, err)
+
+	// an id of another size is refused
+	m.Id = m.Id[1:]
+	_, err = unpackMessage(m.pack())
+	assert.Equal(t, ErrMessageFormat, err)
+}
@@ -0,0 +1,339 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/bitmark-inc/bitmarkd/util"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var (
+	ErrRecipientNotFound = errors.New("recipient not found")
+)
+
+// SessionRecipient is the session key encrypted to a single account
+type SessionRecipient struct {
+	AccountNumber       string
+	EncryptedSessionKey []byte
+}
+
+// MultiSessionData carries one session key to many recipients. The
+// recipients signature is over the whole set of recipients, so no
+// entry can be added or dropped without the sender.
+type MultiSessionData struct {
+	Algorithm           int
+	Recipients          []SessionRecipient
+	RecipientsSignature []byte
+	SessionKeySignature []byte
+}
+
+func (d *MultiSessionData) MarshalJSON() ([]byte, error) {
+	type recipient struct {
+		AccountNumber       string `json:"account_number"`
+		EncryptedSessionKey string `json:"enc_skey"`
+	}
+
+	recipients := make([]recipient, len(d.Recipients))
+	for i, r := range d.Recipients {
+		recipients[i] = recipient{
+			AccountNumber:       r.AccountNumber,
+			EncryptedSessionKey: hex.EncodeToString(r.EncryptedSessionKey),
+		}
+	}
+
+	return json.Marshal(&struct {
+		Algorithm           int         `json:"alg"`
+		Recipients          []recipient `json:"recipients"`
+		RecipientsSignature string      `json:"recipients_sig"`
+		SessionKeySignature string      `json:"skey_sig"`
+	}{
+		Algorithm:           d.Algorithm,
+		Recipients:          recipients,
+		RecipientsSignature: hex.EncodeToString(d.RecipientsSignature),
+		SessionKeySignature: hex.EncodeToString(d.SessionKeySignature),
+	})
+}
+
+func (d *MultiSessionData) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Algorithm  *int `json:"alg"`
+		Recipients []struct {
+			AccountNumber       *string `json:"account_number"`
+			EncryptedSessionKey *string `json:"enc_skey"`
+		} `json:"recipients"`
+		RecipientsSignature *string `json:"recipients_sig"`
+		SessionKeySignature *string `json:"skey_sig"`
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Algorithm == nil || aux.Recipients == nil || aux.RecipientsSignature == nil || aux.SessionKeySignature == nil {
+		return errors.New("missing multi session data field")
+	}
+
+	switch *aux.Algorithm {
+	case Chacha20poly1305, Aes256gcm:
+	default:
+		return errors.New("unsupported algorithm")
+	}
+
+	recipients := make([]SessionRecipient, len(aux.Recipients))
+	for i, r := range aux.Recipients {
+		if r.AccountNumber == nil || r.EncryptedSessionKey == nil {
+			return errors.New("missing multi session data field")
+		}
+		encryptedKey, err := hex.DecodeString(*r.EncryptedSessionKey)
+		if err != nil || len(encryptedKey) < 24+box.Overhead {
+			return errors.New("invalid encrypted session key")
+		}
+		recipients[i] = SessionRecipient{
+			AccountNumber:       *r.AccountNumber,
+			EncryptedSessionKey: encryptedKey,
+		}
+	}
+
+	recipientsSignature, err := hex.DecodeString(*aux.RecipientsSignature)
+	if err != nil || len(recipientsSignature) != ed25519.SignatureSize {
+		return errors.New("invalid recipients signature")
+	}
+	sessionKeySignature, err := hex.DecodeString(*aux.SessionKeySignature)
+	if err != nil || len(sessionKeySignature) != ed25519.SignatureSize {
+		return errors.New("invalid session key signature")
+	}
+
+	d.Algorithm = *aux.Algorithm
+	d.Recipients = recipients
+	d.RecipientsSignature = recipientsSignature
+	d.SessionKeySignature = sessionKeySignature
+	return nil
+}
+
+// packRecipients returns the signed form of the recipients, which
+// consists of the algorithm (varint) followed by the length (varint)
+// and bytes of the account number and the encrypted session key of
+// each recipient, sorted by account number
+func (d *MultiSessionData) packRecipients() []byte {
+	recipients := append([]SessionRecipient{}, d.Recipients...)
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].AccountNumber < recipients[j].AccountNumber
+	})
+
+	var b bytes.Buffer
+	b.Write(util.ToVarint64(uint64(d.Algorithm)))
+	for _, r := range recipients {
+		b.Write(util.ToVarint64(uint64(len(r.AccountNumber))))
+		b.WriteString(r.AccountNumber)
+		b.Write(util.ToVarint64(uint64(len(r.EncryptedSessionKey))))
+		b.Write(r.EncryptedSessionKey)
+	}
+	return b.Bytes()
+}
+
+func (d *MultiSessionData) sign(senderAuthPvtkey []byte) {
+	sort.Slice(d.Recipients, func(i, j int) bool {
+		return d.Recipients[i].AccountNumber < d.Recipients[j].AccountNumber
+	})
+	d.RecipientsSignature = ed25519.Sign(senderAuthPvtkey, d.packRecipients())
+}
+
+// Verify checks the sender's signature over the set of recipients
+func (d *MultiSessionData) Verify(sAuthPubkey []byte) error {
+	if len(sAuthPubkey) != ed25519.PublicKeySize {
+		return errors.New("invalid auth public key size")
+	}
+
+	seen := make(map[string]bool, len(d.Recipients))
+	for _, r := range d.Recipients {
+		if seen[r.AccountNumber] {
+			return errors.New("duplicated recipient")
+		}
+		seen[r.AccountNumber] = true
+	}
+
+	if !ed25519.Verify(sAuthPubkey, d.packRecipients(), d.RecipientsSignature) {
+		return errors.New("invalid recipients signature")
+	}
+	return nil
+}
+
+// CreateMultiSessionData creates the MultiSessionData of a SessionKey
+// for the recipients, which map account numbers to encryption public keys
+func CreateMultiSessionData(sessKey SessionKey, recipients map[string]*[32]byte, senderEncryptionPvtkey *[32]byte, senderAuthPvtkey []byte) (*MultiSessionData, error) {
+	alg, err := sessionKeyAlgorithm(sessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &MultiSessionData{
+		Algorithm:           alg,
+		Recipients:          make([]SessionRecipient, 0, len(recipients)),
+		SessionKeySignature: ed25519.Sign(senderAuthPvtkey, sessKey.Bytes()),
+	}
+
+	for accountNumber, recipientEncryptionPubkey := range recipients {
+		encryptedKey, err := sealSessionKey(sessKey, recipientEncryptionPubkey, senderEncryptionPvtkey)
+		if err != nil {
+			return nil, err
+		}
+		d.Recipients = append(d.Recipients, SessionRecipient{accountNumber, encryptedKey})
+	}
+
+	d.sign(senderAuthPvtkey)
+	return d, nil
+}
+
+// AddRecipient encrypts the session key to one more recipient, or
+// replaces the entry of an existing one, and signs the new set of
+// recipients
+func (d *MultiSessionData) AddRecipient(sessKey SessionKey, accountNumber string, recipientEncryptionPubkey, senderEncryptionPvtkey *[32]byte, senderAuthPvtkey []byte) error {
+	if len(senderAuthPvtkey) != ed25519.PrivateKeySize {
+		return errors.New("invalid auth private key size")
+	}
+
+	senderAuthPubkey := senderAuthPvtkey[ed25519.PrivateKeySize-ed25519.PublicKeySize:]
+	if !ed25519.Verify(senderAuthPubkey, sessKey.Bytes(), d.SessionKeySignature) {
+		return errors.New("session key does not match")
+	}
+
+	encryptedKey, err := sealSessionKey(sessKey, recipientEncryptionPubkey, senderEncryptionPvtkey)
+	if err != nil {
+		return err
+	}
+
+	d.removeRecipient(accountNumber)
+	d.Recipients = append(d.Recipients, SessionRecipient{accountNumber, encryptedKey})
+	d.sign(senderAuthPvtkey)
+	return nil
+}
+
+// RevokeRecipient drops the entry of a recipient and signs the new set
+// of recipients. Only the sender, whose signature is over the current
+// set, can revoke a recipient. Revoking does not take the session key back: a revoked
+// recipient who has read their entry can still decrypt any content
+// encrypted with the key, including content encrypted after the revoke.
+// Use Rotate to encrypt new content with a key they never had.
+func (d *MultiSessionData) RevokeRecipient(accountNumber string, senderAuthPvtkey []byte) error {
+	if len(senderAuthPvtkey) != ed25519.PrivateKeySize {
+		return errors.New("invalid auth private key size")
+	}
+
+	senderAuthPubkey := senderAuthPvtkey[ed25519.PrivateKeySize-ed25519.PublicKeySize:]
+	if err := d.Verify(senderAuthPubkey); err != nil {
+		return err
+	}
+
+	if !d.removeRecipient(accountNumber) {
+		return ErrRecipientNotFound
+	}
+
+	d.sign(senderAuthPvtkey)
+	return nil
+}
+
+// Rotate creates a new session key of the same algorithm, and the
+// MultiSessionData of it for the recipients of d. The encryption public
+// keys of the recipients are given by account number; keys of other
+// accounts are ignored. Content encrypted with the old key stays
+// readable by whoever held it.
+func (d *MultiSessionData) Rotate(recipients map[string]*[32]byte, senderEncryptionPvtkey *[32]byte, senderAuthPvtkey []byte) (SessionKey, *MultiSessionData, error) {
+	if len(senderAuthPvtkey) != ed25519.PrivateKeySize {
+		return nil, nil, errors.New("invalid auth private key size")
+	}
+
+	remaining := make(map[string]*[32]byte, len(d.Recipients))
+	for _, r := range d.Recipients {
+		publicKey, ok := recipients[r.AccountNumber]
+		if !ok {
+			return nil, nil, errors.New("missing encryption public key of recipient")
+		}
+		remaining[r.AccountNumber] = publicKey
+	}
+
+	var sessKey SessionKey
+	var err error
+	switch d.Algorithm {
+	case Chacha20poly1305:
+		sessKey, err = NewChaCha20SessionKey()
+	case Aes256gcm:
+		sessKey, err = NewAES256GCMSessionKey()
+	default:
+		err = errors.New("unsupported algorithm")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rotated, err := CreateMultiSessionData(sessKey, remaining, senderEncryptionPvtkey, senderAuthPvtkey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessKey, rotated, nil
+}
+
+func (d *MultiSessionData) removeRecipient(accountNumber string) bool {
+	for i, r := range d.Recipients {
+		if r.AccountNumber == accountNumber {
+			d.Recipients = append(d.Recipients[:i], d.Recipients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SessionKeyFromMultiSessionData decrypts the session key of a recipient
+// after checking the sender's signatures
+func SessionKeyFromMultiSessionData(data *MultiSessionData, accountNumber string, sEncryptionPubkey, rEncryptionPvtkey *[32]byte, sAuthPubkey []byte) (SessionKey, error) {
+	if err := data.Verify(sAuthPubkey); err != nil {
+		return nil, err
+	}
+
+	for _, r := range data.Recipients {
+		if r.AccountNumber != accountNumber {
+			continue
+		}
+
+		decrypted, err := openSessionKey(r.EncryptedSessionKey, sEncryptionPubkey, rEncryptionPvtkey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ed25519.Verify(sAuthPubkey, decrypted, data.SessionKeySignature) {
+			return nil, errors.New("invalid session key signature")
+		}
+
+		return newSessionKey(data.Algorithm, decrypted)
+	}
+
+	return nil, ErrRecipientNotFound
+}
+
+func sealSessionKey(sessKey SessionKey, recipientEncryptionPubkey, senderEncryptionPvtkey *[32]byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return box.Seal(nonce[:], sessKey.Bytes(), &nonce, recipientEncryptionPubkey, senderEncryptionPvtkey), nil
+}
+
+func openSessionKey(encryptedKey []byte, sEncryptionPubkey, rEncryptionPvtkey *[32]byte) ([]byte, error) {
+	if len(encryptedKey) < 24+box.Overhead {
+		return nil, errors.New("invalid encrypted session key size")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], encryptedKey[:24])
+	decrypted, ok := box.Open(nil, encryptedKey[24:], &nonce, sEncryptionPubkey, rEncryptionPvtkey)
+	if !ok {
+		return nil, errors.New("unable to decrypt")
+	}
+	return decrypted, nil
+}
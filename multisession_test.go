@@ -0,0 +1,145 @@
+package bitmarklib
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiSessionData(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	r1AuthKey, r1EncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	seed, _ := NewSeed(SeedVersion1, Testnet)
+	r2AuthKey, _ := NewAuthKey(seed)
+	r2EncrKey, _ := NewEncrKey(seed)
+
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	data, err := CreateMultiSessionData(sessKey, map[string]*[32]byte{
+		r1AuthKey.AccountNumber(): toKey32(r1EncrKey.PublicKeyBytes()),
+	}, toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.Len(t, data.Recipients, 1)
+
+	err = data.AddRecipient(sessKey, r2AuthKey.AccountNumber(), toKey32(r2EncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.Len(t, data.Recipients, 2)
+
+	b, err := json.Marshal(data)
+	assert.NoError(t, err)
+	var decoded MultiSessionData
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+
+	for _, r := range []struct {
+		account string
+		encrKey EncrKey
+	}{{r1AuthKey.AccountNumber(), r1EncrKey}, {r2AuthKey.AccountNumber(), r2EncrKey}} {
+		key, err := SessionKeyFromMultiSessionData(&decoded, r.account, toKey32(sEncrKey.PublicKeyBytes()), toKey32(r.encrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+		assert.NoError(t, err)
+		assert.Equal(t, sessKey.Bytes(), key.Bytes())
+	}
+
+	assert.NoError(t, decoded.RevokeRecipient(r1AuthKey.AccountNumber(), sAuthKey.PrivateKeyBytes()))
+	_, err = SessionKeyFromMultiSessionData(&decoded, r1AuthKey.AccountNumber(), toKey32(sEncrKey.PublicKeyBytes()), toKey32(r1EncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.Equal(t, ErrRecipientNotFound, err)
+	assert.Equal(t, ErrRecipientNotFound, decoded.RevokeRecipient(r1AuthKey.AccountNumber(), sAuthKey.PrivateKeyBytes()))
+	assert.Error(t, decoded.RevokeRecipient(r2AuthKey.AccountNumber(), sAuthKey.PrivateKeyBytes()[:10]))
+
+	// only the sender can revoke a recipient
+	err = decoded.RevokeRecipient(r2AuthKey.AccountNumber(), r1AuthKey.PrivateKeyBytes())
+	assert.EqualError(t, err, "invalid recipients signature")
+	assert.Len(t, decoded.Recipients, 1)
+	assert.NoError(t, decoded.Verify(sAuthKey.PublicKeyBytes()))
+
+	// the revoked recipient can still read the old entry, so the key is
+	// rotated for the remaining recipients
+	keys := map[string]*[32]byte{
+		r1AuthKey.AccountNumber(): toKey32(r1EncrKey.PublicKeyBytes()),
+		r2AuthKey.AccountNumber(): toKey32(r2EncrKey.PublicKeyBytes()),
+	}
+	newKey, rotated, err := decoded.Rotate(keys, toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.NotEqual(t, sessKey.Bytes(), newKey.Bytes())
+	assert.Equal(t, Chacha20poly1305, rotated.Algorithm)
+	if assert.Len(t, rotated.Recipients, 1) {
+		assert.Equal(t, r2AuthKey.AccountNumber(), rotated.Recipients[0].AccountNumber)
+	}
+	key, err := SessionKeyFromMultiSessionData(rotated, r2AuthKey.AccountNumber(), toKey32(sEncrKey.PublicKeyBytes()), toKey32(r2EncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, newKey.Bytes(), key.Bytes())
+
+	delete(keys, r2AuthKey.AccountNumber())
+	_, _, err = decoded.Rotate(keys, toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.Error(t, err)
+}
+
+func TestMultiSessionDataJSONStrict(t *testing.T) {
+	encKey := strings.Repeat("00", 24+16)
+	sig := strings.Repeat("01", 64)
+	valid := `{"alg": 0, "recipients": [{"account_number": "a", "enc_skey": "` + encKey + `"}], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`
+
+	var data MultiSessionData
+	assert.NoError(t, json.Unmarshal([]byte(valid), &data))
+
+	for _, s := range []string{
+		`{"recipients": [], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 2, "recipients": [], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients": [{"account_number": "a", "enc_skey": "000"}], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients": [{"account_number": "a", "enc_skey": "0000"}], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients": [{"enc_skey": "` + encKey + `"}], "recipients_sig": "` + sig + `", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients": [], "recipients_sig": "0101", "skey_sig": "` + sig + `"}`,
+		`{"alg": 0, "recipients": [], "recipients_sig": "` + sig + `", "skey_sig": "` + sig[1:] + `"}`,
+		`{"alg": 0, "recipients": [], "recipients_sig": "` + sig + `"}`,
+	} {
+		var data MultiSessionData
+		assert.Error(t, json.Unmarshal([]byte(s), &data), s)
+	}
+}
+
+func TestMultiSessionDataTampered(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	rAuthKey, rEncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	data, err := CreateMultiSessionData(sessKey, map[string]*[32]byte{
+		rAuthKey.AccountNumber(): toKey32(rEncrKey.PublicKeyBytes()),
+	}, toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	assert.NoError(t, data.Verify(sAuthKey.PublicKeyBytes()))
+
+	// an entry added without the sender's signature
+	data.Recipients = append(data.Recipients, SessionRecipient{"eJNGZYLxtaSvLJCx7LTCu6mBqbJus2hpNXi1VDkNGRiNjsyzgp", data.Recipients[0].EncryptedSessionKey})
+	assert.Error(t, data.Verify(sAuthKey.PublicKeyBytes()))
+
+	// a different session key can not be added
+	otherKey, _ := NewChaCha20SessionKey()
+	err = data.AddRecipient(otherKey, rAuthKey.AccountNumber(), toKey32(rEncrKey.PublicKeyBytes()), toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.Error(t, err)
+}
+
+func TestMultiSessionDataAlgorithm(t *testing.T) {
+	sAuthKey, sEncrKey := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	rAuthKey, rEncrKey := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+
+	sessKey, err := NewAES256GCMSessionKey()
+	assert.NoError(t, err)
+
+	data, err := CreateMultiSessionData(sessKey, map[string]*[32]byte{
+		rAuthKey.AccountNumber(): toKey32(rEncrKey.PublicKeyBytes()),
+	}, toKey32(sEncrKey.PrivateKeyBytes()), sAuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	key, err := SessionKeyFromMultiSessionData(data, rAuthKey.AccountNumber(), toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.IsType(t, &AES256GCMSessionKey{}, key)
+
+	// the algorithm is covered by the recipients signature
+	data.Algorithm = Chacha20poly1305
+	assert.Error(t, data.Verify(sAuthKey.PublicKeyBytes()))
+}
@@ -0,0 +1,346 @@
+// Package outbox keeps signed records on disk until they are submitted,
+// so that a record signed before a crash is not lost
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultMaxAttempts = 10
+)
+
+// Kind of a record in the outbox
+type Kind string
+
+const (
+	KindIssue    Kind = "issue"
+	KindTransfer Kind = "transfer"
+)
+
+// State of a record in the outbox
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateSubmitted State = "submitted"
+	StateFailed    State = "failed"
+)
+
+var (
+	ErrNotFound = errors.New("record not found in outbox")
+
+	entriesBucket = []byte("entries")
+	queueBucket   = []byte("queue")
+)
+
+// Entry is a signed record and the outcome of its submission
+type Entry struct {
+	TxId      string          `json:"txId"`
+	Kind      Kind            `json:"kind"`
+	Asset     json.RawMessage `json:"asset,omitempty"`
+	Record    json.RawMessage `json:"record"`
+	State     State           `json:"state"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+
+	seq uint64
+}
+
+// Submitter sends records to the network. Submitting a record that the
+// network already has must not fail, as a record is submitted again if
+// the process stops before its outcome is stored.
+type Submitter interface {
+	SubmitIssue(ctx context.Context, asset *bitmarklib.Asset, issue *bitmarklib.Issue) error
+	SubmitTransfer(ctx context.Context, transfer *bitmarklib.Transfer) error
+}
+
+// PermanentError is returned by a Submitter for a record that will never
+// be accepted, which is not retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Outbox is a queue of signed records in a bbolt database. Records are
+// submitted in the order they were added, and are only added once, keyed
+// by their txid.
+type Outbox struct {
+	db        *bolt.DB
+	submitter Submitter
+
+	// MaxAttempts is the number of submissions of a record that fail
+	// before it is given up
+	MaxAttempts int
+
+	flush sync.Mutex
+}
+
+// Open opens or creates the outbox database at path
+func Open(path string, submitter Submitter) (*Outbox, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(entriesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(queueBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Outbox{
+		db:          db,
+		submitter:   submitter,
+		MaxAttempts: DefaultMaxAttempts,
+	}, nil
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// AddIssue stores a signed issue, with its asset if it is not registered
+// yet, and returns its txid. Adding an issue that is already in the
+// outbox does nothing.
+func (o *Outbox) AddIssue(asset *bitmarklib.Asset, issue *bitmarklib.Issue) (string, error) {
+	txId, err := issue.TxId()
+	if err != nil {
+		return "", err
+	}
+
+	e := &Entry{TxId: txId, Kind: KindIssue}
+	if asset != nil {
+		if e.Asset, err = json.Marshal(asset); err != nil {
+			return "", err
+		}
+	}
+	if e.Record, err = json.Marshal(issue); err != nil {
+		return "", err
+	}
+	return txId, o.add(e)
+}
+
+// AddTransfer stores a signed transfer and returns its txid. Adding a
+// transfer that is already in the outbox does nothing.
+func (o *Outbox) AddTransfer(transfer *bitmarklib.Transfer) (string, error) {
+	txId, err := transfer.TxId()
+	if err != nil {
+		return "", err
+	}
+
+	record, err := json.Marshal(transfer)
+	if err != nil {
+		return "", err
+	}
+	return txId, o.add(&Entry{TxId: txId, Kind: KindTransfer, Record: record})
+}
+
+func (o *Outbox) add(e *Entry) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		if entries.Get([]byte(e.TxId)) != nil {
+			return nil
+		}
+
+		queue := tx.Bucket(queueBucket)
+		seq, err := queue.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		e.seq = seq
+		e.State = StatePending
+		e.CreatedAt = time.Now().UTC()
+		e.UpdatedAt = e.CreatedAt
+		if err := queue.Put(seqKey(seq), []byte(e.TxId)); err != nil {
+			return err
+		}
+		return putEntry(entries, e)
+	})
+}
+
+// Get returns the entry of a txid
+func (o *Outbox) Get(txId string) (*Entry, error) {
+	var e *Entry
+	err := o.db.View(func(tx *bolt.Tx) error {
+		var err error
+		e, err = getEntry(tx.Bucket(entriesBucket), txId)
+		return err
+	})
+	return e, err
+}
+
+// Pending returns the entries waiting to be submitted, oldest first
+func (o *Outbox) Pending() ([]*Entry, error) {
+	var pending []*Entry
+	err := o.db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		return tx.Bucket(queueBucket).ForEach(func(_, txId []byte) error {
+			e, err := getEntry(entries, string(txId))
+			if err != nil {
+				return err
+			}
+			pending = append(pending, e)
+			return nil
+		})
+	})
+	return pending, err
+}
+
+// Flush submits the pending entries in order. It stops at the first
+// entry that fails but may be accepted later, as the entries after it
+// may depend on it, and returns that error.
+func (o *Outbox) Flush(ctx context.Context) error {
+	o.flush.Lock()
+	defer o.flush.Unlock()
+
+	pending, err := o.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := o.submit(ctx, e)
+		e.Attempts++
+		e.UpdatedAt = time.Now().UTC()
+
+		var permanent *PermanentError
+		switch {
+		case err == nil:
+			e.State = StateSubmitted
+			e.LastError = ""
+		case errors.As(err, &permanent) || e.Attempts >= o.MaxAttempts:
+			e.State = StateFailed
+			e.LastError = err.Error()
+		default:
+			e.LastError = err.Error()
+		}
+
+		if err := o.update(e); err != nil {
+			return err
+		}
+		if e.State == StatePending {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run flushes the outbox every interval until ctx is done
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a failed flush is retried on the next tick, and its error is
+		// stored with the entry
+		o.Flush(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (o *Outbox) submit(ctx context.Context, e *Entry) error {
+	switch e.Kind {
+	case KindIssue:
+		var asset *bitmarklib.Asset
+		if len(e.Asset) != 0 {
+			asset = &bitmarklib.Asset{}
+			if err := json.Unmarshal(e.Asset, asset); err != nil {
+				return &PermanentError{err}
+			}
+		}
+		var issue bitmarklib.Issue
+		if err := json.Unmarshal(e.Record, &issue); err != nil {
+			return &PermanentError{err}
+		}
+		return o.submitter.SubmitIssue(ctx, asset, &issue)
+
+	case KindTransfer:
+		var transfer bitmarklib.Transfer
+		if err := json.Unmarshal(e.Record, &transfer); err != nil {
+			return &PermanentError{err}
+		}
+		return o.submitter.SubmitTransfer(ctx, &transfer)
+
+	default:
+		return &PermanentError{errors.New("unknown record kind")}
+	}
+}
+
+// update stores an entry, and takes it off the queue once it is final
+func (o *Outbox) update(e *Entry) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		if e.State != StatePending {
+			if err := tx.Bucket(queueBucket).Delete(seqKey(e.seq)); err != nil {
+				return err
+			}
+		}
+		return putEntry(tx.Bucket(entriesBucket), e)
+	})
+}
+
+type storedEntry struct {
+	*Entry
+	Seq uint64 `json:"seq"`
+}
+
+func putEntry(b *bolt.Bucket, e *Entry) error {
+	data, err := json.Marshal(storedEntry{e, e.seq})
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(e.TxId), data)
+}
+
+func getEntry(b *bolt.Bucket, txId string) (*Entry, error) {
+	data := b.Get([]byte(txId))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	stored := storedEntry{Entry: &Entry{}}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	stored.Entry.seq = stored.Seq
+	return stored.Entry, nil
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
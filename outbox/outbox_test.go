@@ -0,0 +1,296 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	netrpc "net/rpc"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/bitmark-inc/go-bitmarklib/api"
+	"github.com/bitmark-inc/go-bitmarklib/bitmarktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAccount(t *testing.T) *bitmarklib.AccountKeys {
+	seed, err := bitmarklib.NewSeed(bitmarklib.SeedVersion1, bitmarklib.Testnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func newIssue(t *testing.T, owner *bitmarklib.AccountKeys, fingerprint string) (*bitmarklib.Asset, *bitmarklib.Issue) {
+	asset := bitmarklib.NewAsset("test", fingerprint)
+	assert.NoError(t, asset.ClaimedBy(owner.AuthKey))
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(owner.AuthKey))
+	return &asset, &issue
+}
+
+func open(t *testing.T, path string, submitter Submitter) *Outbox {
+	o, err := Open(path, submitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// flakySubmitter fails the first calls, optionally after the record has
+// reached the network, as when a process stops before the reply
+type flakySubmitter struct {
+	Submitter
+	failures  int
+	delivered bool
+	calls     int
+}
+
+func (s *flakySubmitter) SubmitIssue(ctx context.Context, asset *bitmarklib.Asset, issue *bitmarklib.Issue) error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		if s.delivered {
+			s.Submitter.SubmitIssue(ctx, asset, issue)
+		}
+		return errors.New("connection reset")
+	}
+	return s.Submitter.SubmitIssue(ctx, asset, issue)
+}
+
+func TestOutbox(t *testing.T) {
+	server := bitmarktest.NewServer()
+	defer server.Close()
+	client := server.APIClient()
+	ctx := context.Background()
+
+	alice := newAccount(t)
+	bob := newAccount(t)
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	o := open(t, path, APISubmitter{client})
+	asset, issue := newIssue(t, alice, "fingerprint1")
+	bitmarkId, err := o.AddIssue(asset, issue)
+	assert.NoError(t, err)
+
+	transfer, _ := bitmarklib.NewTransfer(bitmarkId, bob.AuthKey.AccountNumber())
+	assert.NoError(t, transfer.ClaimedBy(alice.AuthKey))
+	transferId, err := o.AddTransfer(transfer)
+	assert.NoError(t, err)
+
+	// adding the same record again does nothing
+	again, err := o.AddIssue(asset, issue)
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkId, again)
+
+	// the records survive a restart
+	assert.NoError(t, o.Close())
+	o = open(t, path, APISubmitter{client})
+	defer o.Close()
+
+	pending, err := o.Pending()
+	assert.NoError(t, err)
+	if !assert.Len(t, pending, 2) {
+		return
+	}
+	assert.Equal(t, bitmarkId, pending[0].TxId)
+	assert.Equal(t, transferId, pending[1].TxId)
+
+	assert.NoError(t, o.Flush(ctx))
+	pending, _ = o.Pending()
+	assert.Empty(t, pending)
+
+	e, err := o.Get(transferId)
+	assert.NoError(t, err)
+	assert.Equal(t, StateSubmitted, e.State)
+	assert.Equal(t, 1, e.Attempts)
+
+	bitmark, err := client.GetBitmark(ctx, bitmarkId)
+	assert.NoError(t, err)
+	assert.Equal(t, transferId, bitmark.HeadId)
+	assert.Equal(t, bob.AuthKey.AccountNumber(), bitmark.Owner)
+
+	_, err = o.Get("unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestOutboxRetry(t *testing.T) {
+	server := bitmarktest.NewServer()
+	defer server.Close()
+	client := server.APIClient()
+	ctx := context.Background()
+	alice := newAccount(t)
+
+	// the issue reaches the network, but the reply is lost twice
+	submitter := &flakySubmitter{Submitter: APISubmitter{client}, failures: 2, delivered: true}
+	o := open(t, filepath.Join(t.TempDir(), "outbox.db"), submitter)
+	defer o.Close()
+
+	asset, issue := newIssue(t, alice, "fingerprint1")
+	bitmarkId, _ := o.AddIssue(asset, issue)
+	_, second := newIssue(t, alice, "fingerprint1")
+	secondId, _ := o.AddIssue(nil, second)
+
+	// a temporary failure holds back the records after it
+	assert.Error(t, o.Flush(ctx))
+	e, _ := o.Get(bitmarkId)
+	assert.Equal(t, StatePending, e.State)
+	assert.Equal(t, "connection reset", e.LastError)
+	e, _ = o.Get(secondId)
+	assert.Equal(t, 0, e.Attempts)
+
+	assert.Error(t, o.Flush(ctx))
+	assert.NoError(t, o.Flush(ctx))
+
+	e, _ = o.Get(bitmarkId)
+	assert.Equal(t, StateSubmitted, e.State)
+	assert.Equal(t, 3, e.Attempts)
+	assert.Empty(t, e.LastError)
+
+	// submitted three times, but minted once
+	tx, err := client.GetTx(ctx, bitmarkId)
+	assert.NoError(t, err)
+	assert.Equal(t, api.StatusPending, tx.Status)
+	_, err = client.GetTx(ctx, secondId)
+	assert.NoError(t, err)
+}
+
+// crashingSubmitter submits records, then stops the process before the
+// outcome of a record of the kind is stored
+type crashingSubmitter struct {
+	Submitter
+	kind Kind
+}
+
+func (s crashingSubmitter) SubmitIssue(ctx context.Context, asset *bitmarklib.Asset, issue *bitmarklib.Issue) error {
+	err := s.Submitter.SubmitIssue(ctx, asset, issue)
+	if s.kind == KindIssue {
+		panic("crash")
+	}
+	return err
+}
+
+func (s crashingSubmitter) SubmitTransfer(ctx context.Context, transfer *bitmarklib.Transfer) error {
+	err := s.Submitter.SubmitTransfer(ctx, transfer)
+	if s.kind == KindTransfer {
+		panic("crash")
+	}
+	return err
+}
+
+func TestOutboxCrash(t *testing.T) {
+	server := bitmarktest.NewServer()
+	defer server.Close()
+	client := server.APIClient()
+	ctx := context.Background()
+	alice := newAccount(t)
+	bob := newAccount(t)
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	flush := func(o *Outbox) (crashed bool) {
+		defer func() {
+			crashed = recover() != nil
+		}()
+		o.Flush(ctx)
+		return false
+	}
+
+	o := open(t, path, crashingSubmitter{APISubmitter{client}, KindIssue})
+	asset, issue := newIssue(t, alice, "fingerprint1")
+	bitmarkId, _ := o.AddIssue(asset, issue)
+	transfer, _ := bitmarklib.NewTransfer(bitmarkId, bob.AuthKey.AccountNumber())
+	assert.NoError(t, transfer.ClaimedBy(alice.AuthKey))
+	transferId, _ := o.AddTransfer(transfer)
+	assert.True(t, flush(o))
+	assert.NoError(t, o.Close())
+
+	// the network refuses both records as duplicates when they are
+	// submitted again, and they count as submitted
+	o = open(t, path, crashingSubmitter{APISubmitter{client}, KindTransfer})
+	assert.True(t, flush(o))
+	e, _ := o.Get(bitmarkId)
+	assert.Equal(t, StateSubmitted, e.State)
+	assert.Empty(t, e.LastError)
+	assert.NoError(t, o.Close())
+
+	o = open(t, path, APISubmitter{client})
+	defer o.Close()
+	assert.NoError(t, o.Flush(ctx))
+	e, _ = o.Get(transferId)
+	assert.Equal(t, StateSubmitted, e.State)
+	assert.Empty(t, e.LastError)
+
+	bitmark, err := client.GetBitmark(ctx, bitmarkId)
+	assert.NoError(t, err)
+	assert.Equal(t, transferId, bitmark.HeadId)
+}
+
+func TestIgnoreExisting(t *testing.T) {
+	assert.NoError(t, ignoreExisting(netrpc.ServerError(fault.ErrTransactionAlreadyExists.Error())))
+	assert.Error(t, ignoreExisting(netrpc.ServerError("transaction not found")))
+	assert.NoError(t, ignoreExisting(nil))
+}
+
+func TestOutboxFailures(t *testing.T) {
+	server := bitmarktest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	alice := newAccount(t)
+
+	o := open(t, filepath.Join(t.TempDir(), "outbox.db"), APISubmitter{server.APIClient()})
+	defer o.Close()
+
+	// a transfer of an unknown bitmark is rejected for good, and does
+	// not hold back the issue after it
+	transfer, _ := bitmarklib.NewTransfer("8b8cd7d19328c7ea3fa5fda4fd6bbc1b7fb2c8a4a0d85a7e0fe29c95fc3ad4e7", alice.AuthKey.AccountNumber())
+	assert.NoError(t, transfer.ClaimedBy(alice.AuthKey))
+	transferId, _ := o.AddTransfer(transfer)
+	asset, issue := newIssue(t, alice, "fingerprint1")
+	bitmarkId, _ := o.AddIssue(asset, issue)
+
+	assert.NoError(t, o.Flush(ctx))
+	e, _ := o.Get(transferId)
+	assert.Equal(t, StateFailed, e.State)
+	assert.Contains(t, e.LastError, "not found")
+	e, _ = o.Get(bitmarkId)
+	assert.Equal(t, StateSubmitted, e.State)
+
+	// temporary failures are given up after MaxAttempts
+	submitter := &flakySubmitter{Submitter: APISubmitter{server.APIClient()}, failures: 100}
+	o.submitter = submitter
+	o.MaxAttempts = 3
+	_, issue = newIssue(t, alice, "fingerprint1")
+	issueId, _ := o.AddIssue(nil, issue)
+
+	assert.Error(t, o.Flush(ctx))
+	assert.Error(t, o.Flush(ctx))
+	assert.NoError(t, o.Flush(ctx))
+	e, _ = o.Get(issueId)
+	assert.Equal(t, StateFailed, e.State)
+	assert.Equal(t, 3, submitter.calls)
+}
+
+func TestOutboxRun(t *testing.T) {
+	server := bitmarktest.NewServer()
+	defer server.Close()
+	alice := newAccount(t)
+
+	o := open(t, filepath.Join(t.TempDir(), "outbox.db"), APISubmitter{server.APIClient()})
+	defer o.Close()
+
+	asset, issue := newIssue(t, alice, "fingerprint1")
+	bitmarkId, _ := o.AddIssue(asset, issue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, o.Run(ctx, 0x7fffffff))
+
+	// nothing is submitted once the context is done
+	e, _ := o.Get(bitmarkId)
+	assert.Equal(t, StatePending, e.State)
+}
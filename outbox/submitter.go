@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/bitmark-inc/go-bitmarklib/api"
+	"github.com/bitmark-inc/go-bitmarklib/rpc"
+)
+
+// APISubmitter submits records through the Bitmark API. A record that
+// is refused is looked up by its txid, and counts as submitted if the
+// network already has it.
+type APISubmitter struct {
+	Client *api.Client
+}
+
+func (s APISubmitter) SubmitIssue(ctx context.Context, asset *bitmarklib.Asset, issue *bitmarklib.Issue) error {
+	var assets []bitmarklib.Asset
+	if asset != nil {
+		assets = append(assets, *asset)
+	}
+	_, err := s.Client.Issue(ctx, assets, []bitmarklib.Issue{*issue})
+	if err != nil && s.known(ctx, issue.TxId) {
+		return nil
+	}
+	return classifyAPIError(err)
+}
+
+func (s APISubmitter) SubmitTransfer(ctx context.Context, transfer *bitmarklib.Transfer) error {
+	_, err := s.Client.Transfer(ctx, transfer)
+	if err != nil && s.known(ctx, transfer.TxId) {
+		return nil
+	}
+	return classifyAPIError(err)
+}
+
+// known tells whether the network has the record of the txid
+func (s APISubmitter) known(ctx context.Context, txId func() (string, error)) bool {
+	id, err := txId()
+	if err != nil {
+		return false
+	}
+	_, err = s.Client.GetTx(ctx, id)
+	return err == nil
+}
+
+// classifyAPIError makes a rejection of the request itself permanent.
+// Server errors, timeouts and rate limits are worth retrying.
+func classifyAPIError(err error) error {
+	var e *api.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	if e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests {
+		return &PermanentError{err}
+	}
+	return err
+}
+
+// RPCSubmitter submits records straight to a bitmarkd node. A record
+// that the node already has counts as submitted.
+type RPCSubmitter struct {
+	Client *rpc.Client
+}
+
+func (s RPCSubmitter) SubmitIssue(ctx context.Context, asset *bitmarklib.Asset, issue *bitmarklib.Issue) error {
+	var assets []bitmarklib.Asset
+	if asset != nil {
+		assets = append(assets, *asset)
+	}
+	_, err := s.Client.CreateBitmarks(ctx, assets, []bitmarklib.Issue{*issue})
+	return ignoreExisting(err)
+}
+
+func (s RPCSubmitter) SubmitTransfer(ctx context.Context, transfer *bitmarklib.Transfer) error {
+	_, err := s.Client.Transfer(ctx, transfer)
+	return ignoreExisting(err)
+}
+
+// ignoreExisting drops the error of a node that already has the record.
+// Errors of the node reach the client as their text only.
+func ignoreExisting(err error) error {
+	if err != nil && err.Error() == fault.ErrTransactionAlreadyExists.Error() {
+		return nil
+	}
+	return err
+}
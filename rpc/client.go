@@ -0,0 +1,217 @@
+// Package rpc is a client of the JSON-RPC interface of bitmarkd, for
+// submitting records to a node without going through the Bitmark API
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/bitmark-inc/go-bitmarklib"
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	ErrCertificateMismatch = errors.New("node certificate does not match the pinned fingerprints")
+)
+
+// Client sends requests to a bitmarkd node over a single TLS connection
+type Client struct {
+	conn   net.Conn
+	client *netrpc.Client
+}
+
+// Dial connects to the RPC port of a node. Nodes usually have self
+// signed certificates, so tlsConfig should normally come from
+// PinnedTLSConfig.
+func Dial(ctx context.Context, address string, tlsConfig *tls.Config) (*Client, error) {
+	dialer := &tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn:   conn,
+		client: netrpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)),
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// CertificateFingerprint returns the SHA3-256 digest of a DER encoded
+// certificate, which is how bitmarkd announces the certificates of nodes
+func CertificateFingerprint(der []byte) [32]byte {
+	return sha3.Sum256(der)
+}
+
+// PinnedTLSConfig returns a TLS config that only accepts a node whose
+// certificate has one of the fingerprints, whoever signed it
+func PinnedTLSConfig(fingerprints ...[32]byte) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+
+		// the chain is not checked, the pin replaces it
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrCertificateMismatch
+			}
+			fingerprint := CertificateFingerprint(rawCerts[0])
+			for _, f := range fingerprints {
+				if bytes.Equal(f[:], fingerprint[:]) {
+					return nil
+				}
+			}
+			return ErrCertificateMismatch
+		},
+	}
+}
+
+// call sends a request and waits for the reply, or for ctx to be done.
+// A call abandoned by ctx leaves the connection usable.
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	call := c.client.Go(method, args, reply, make(chan *netrpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AssetStatus is the result of registering an asset
+type AssetStatus struct {
+	AssetId   string `json:"id"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// IssueStatus is the result of an issue
+type IssueStatus struct {
+	TxId string `json:"txId"`
+}
+
+// CreateReply is the reply of Bitmarks.Create. Issues that need payment
+// come with the payment id, nonce and the accepted payments.
+type CreateReply struct {
+	Assets     []AssetStatus              `json:"assets"`
+	Issues     []IssueStatus              `json:"issues"`
+	PayId      string                     `json:"payId"`
+	PayNonce   string                     `json:"payNonce"`
+	Difficulty string                     `json:"difficulty,omitempty"`
+	Payments   map[string]json.RawMessage `json:"payments,omitempty"`
+}
+
+type createArguments struct {
+	Assets []bitmarklib.Asset `json:"assets"`
+	Issues []bitmarklib.Issue `json:"issues"`
+}
+
+// RegisterAssets registers assets without issuing them. Nodes since
+// v0.6 have no Assets.Register, and register assets sent to
+// Bitmarks.Create instead, so that is what is called.
+func (c *Client) RegisterAssets(ctx context.Context, assets []bitmarklib.Asset) ([]AssetStatus, error) {
+	reply, err := c.CreateBitmarks(ctx, assets, nil)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Assets, nil
+}
+
+// CreateBitmarks registers the assets and issues the bitmarks
+func (c *Client) CreateBitmarks(ctx context.Context, assets []bitmarklib.Asset, issues []bitmarklib.Issue) (*CreateReply, error) {
+	var reply CreateReply
+	if err := c.call(ctx, "Bitmarks.Create", &createArguments{assets, issues}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// TransferReply is the reply of Bitmark.Transfer
+type TransferReply struct {
+	TxId      string                     `json:"txId"`
+	BitmarkId string                     `json:"bitmarkId"`
+	PayId     string                     `json:"payId"`
+	Payments  map[string]json.RawMessage `json:"payments"`
+}
+
+// Transfer submits a transfer signed by the current owner
+func (c *Client) Transfer(ctx context.Context, transfer *bitmarklib.Transfer) (*TransferReply, error) {
+	if transfer == nil || transfer.BitmarkTransferUnratified == nil {
+		return nil, errors.New("missing transfer")
+	}
+
+	var reply TransferReply
+	if err := c.call(ctx, "Bitmark.Transfer", transfer.BitmarkTransferUnratified, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// ProvenanceRecord is a record in the history of a bitmark. Data is the
+// record itself, whose form depends on Record.
+type ProvenanceRecord struct {
+	Record  string          `json:"record"`
+	IsOwner bool            `json:"isOwner"`
+	TxId    string          `json:"txId,omitempty"`
+	InBlock uint64          `json:"inBlock"`
+	AssetId string          `json:"assetId,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type provenanceArguments struct {
+	TxId  string `json:"txId"`
+	Count int    `json:"count"`
+}
+
+type provenanceReply struct {
+	Data []ProvenanceRecord `json:"data"`
+}
+
+// Provenance returns up to count records of the history of a bitmark,
+// starting from the transaction txId and going back to its asset
+func (c *Client) Provenance(ctx context.Context, txId string, count int) ([]ProvenanceRecord, error) {
+	var reply provenanceReply
+	if err := c.call(ctx, "Bitmark.Provenance", &provenanceArguments{txId, count}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// NodeInfo is the reply of Node.Info
+type NodeInfo struct {
+	Chain string `json:"chain"`
+	Mode  string `json:"mode"`
+	Block struct {
+		Height uint64 `json:"height"`
+		Hash   string `json:"hash"`
+	} `json:"block"`
+	RPCs                uint64 `json:"rpcs"`
+	Peers               uint64 `json:"peers"`
+	TransactionCounters struct {
+		Pending  int `json:"pending"`
+		Verified int `json:"verified"`
+	} `json:"transactionCounters"`
+	Difficulty float64 `json:"difficulty"`
+	Hashrate   float64 `json:"hashrate,omitempty"`
+	Version    string  `json:"version"`
+	Uptime     string  `json:"uptime"`
+	PublicKey  string  `json:"publicKey"`
+}
+
+// Info returns the state of the node
+func (c *Client) Info(ctx context.Context) (*NodeInfo, error) {
+	var reply NodeInfo
+	if err := c.call(ctx, "Node.Info", struct{}{}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
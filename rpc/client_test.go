@@ -0,0 +1,259 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/go-bitmarklib"
+	"github.com/stretchr/testify/assert"
+)
+
+// the arguments of the fake node are decoded into bitmarkd's own types
+
+type FakeCreateArguments struct {
+	Assets []*transactionrecord.AssetData    `json:"assets"`
+	Issues []*transactionrecord.BitmarkIssue `json:"issues"`
+}
+
+type FakeProvenanceArguments struct {
+	TxId  string `json:"txId"`
+	Count int    `json:"count"`
+}
+
+type FakeProvenanceReply struct {
+	Data []ProvenanceRecord `json:"data"`
+}
+
+type fakeBitmarks struct{}
+
+func (fakeBitmarks) Create(args *FakeCreateArguments, reply *CreateReply) error {
+	for _, a := range args.Assets {
+		if _, err := a.Pack(a.Registrant); err != nil {
+			return err
+		}
+		id, _ := a.AssetId().MarshalText()
+		reply.Assets = append(reply.Assets, AssetStatus{AssetId: string(id)})
+	}
+	for _, i := range args.Issues {
+		packed, err := i.Pack(i.Owner)
+		if err != nil {
+			return err
+		}
+		txId, _ := packed.MakeLink().MarshalText()
+		reply.Issues = append(reply.Issues, IssueStatus{TxId: string(txId)})
+	}
+	return nil
+}
+
+type fakeBitmark struct {
+	keys *bitmarklib.AccountKeys
+}
+
+func (b fakeBitmark) Transfer(args *transactionrecord.BitmarkTransferUnratified, reply *TransferReply) error {
+	packed, err := args.Pack(b.keys.AuthKey.PublicKey())
+	if err != nil {
+		return err
+	}
+	txId, _ := packed.MakeLink().MarshalText()
+	link, _ := args.Link.MarshalText()
+	reply.TxId = string(txId)
+	reply.BitmarkId = string(link)
+	return nil
+}
+
+func (fakeBitmark) Provenance(args *FakeProvenanceArguments, reply *FakeProvenanceReply) error {
+	if args.TxId == "" {
+		return errors.New("invalid txId")
+	}
+	for i := 0; i < args.Count; i++ {
+		reply.Data = append(reply.Data, ProvenanceRecord{
+			Record:  "BitmarkTransferUnratified",
+			TxId:    args.TxId,
+			InBlock: uint64(100 - i),
+			Data:    json.RawMessage(`{}`),
+		})
+	}
+	return nil
+}
+
+type fakeNode struct{}
+
+func (fakeNode) Info(args struct{}, reply *NodeInfo) error {
+	reply.Chain = "testing"
+	reply.Mode = "Normal"
+	reply.Block.Height = 100
+	reply.Version = "0.11.5"
+	return nil
+}
+
+func (fakeNode) Slow(args struct{}, reply *NodeInfo) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+// listen starts a fake node and returns its address and the fingerprint
+// of its certificate
+func listen(t *testing.T, keys *bitmarklib.AccountKeys) (string, [32]byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bitmarkd"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	server := netrpc.NewServer()
+	server.RegisterName("Bitmarks", fakeBitmarks{})
+	server.RegisterName("Bitmark", fakeBitmark{keys})
+	server.RegisterName("Node", fakeNode{})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+
+	return ln.Addr().String(), CertificateFingerprint(der)
+}
+
+func mustAccountKeys(t *testing.T) *bitmarklib.AccountKeys {
+	seed, err := bitmarklib.SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bitmarklib.NewAccountKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func dial(t *testing.T, address string, fingerprint [32]byte) *Client {
+	c, err := Dial(context.Background(), address, PinnedTLSConfig(fingerprint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCreateAndTransfer(t *testing.T) {
+	keys := mustAccountKeys(t)
+	address, fingerprint := listen(t, keys)
+	c := dial(t, address, fingerprint)
+	ctx := context.Background()
+
+	asset := bitmarklib.NewAsset("test", "fingerprint")
+	assert.NoError(t, asset.ClaimedBy(keys.AuthKey))
+	issue := bitmarklib.NewIssue(asset.AssetId())
+	assert.NoError(t, issue.ClaimedBy(keys.AuthKey))
+
+	assets, err := c.RegisterAssets(ctx, []bitmarklib.Asset{asset})
+	assert.NoError(t, err)
+	assetId, _ := asset.AssetId().MarshalText()
+	assert.Equal(t, []AssetStatus{{AssetId: string(assetId)}}, assets)
+
+	reply, err := c.CreateBitmarks(ctx, []bitmarklib.Asset{asset}, []bitmarklib.Issue{issue})
+	assert.NoError(t, err)
+	if !assert.Len(t, reply.Issues, 1) {
+		return
+	}
+	bitmarkId := reply.Issues[0].TxId
+
+	// a record with a bad signature is rejected by the node
+	forged := issue
+	forged.Signature = append([]byte{}, issue.Signature...)
+	forged.Signature[0] ^= 0x01
+	_, err = c.CreateBitmarks(ctx, nil, []bitmarklib.Issue{forged})
+	assert.Error(t, err)
+
+	transfer, err := bitmarklib.NewTransfer(bitmarkId, keys.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+	assert.NoError(t, transfer.ClaimedBy(keys.AuthKey))
+	transferReply, err := c.Transfer(ctx, transfer)
+	assert.NoError(t, err)
+	assert.Equal(t, bitmarkId, transferReply.BitmarkId)
+	assert.NotEmpty(t, transferReply.TxId)
+}
+
+func TestProvenanceAndInfo(t *testing.T) {
+	address, fingerprint := listen(t, mustAccountKeys(t))
+	c := dial(t, address, fingerprint)
+	ctx := context.Background()
+
+	records, err := c.Provenance(ctx, "tx1", 3)
+	assert.NoError(t, err)
+	if !assert.Len(t, records, 3) {
+		return
+	}
+	assert.Equal(t, uint64(99), records[1].InBlock)
+
+	_, err = c.Provenance(ctx, "", 3)
+	assert.EqualError(t, err, "invalid txId")
+
+	info, err := c.Info(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), info.Block.Height)
+	assert.Equal(t, "0.11.5", info.Version)
+}
+
+func TestContext(t *testing.T) {
+	address, fingerprint := listen(t, mustAccountKeys(t))
+	c := dial(t, address, fingerprint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.call(ctx, "Node.Slow", struct{}{}, &NodeInfo{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the connection still works after a call is abandoned
+	_, err = c.Info(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestCertificatePinning(t *testing.T) {
+	address, _ := listen(t, mustAccountKeys(t))
+
+	_, err := Dial(context.Background(), address, PinnedTLSConfig([32]byte{}))
+	assert.Error(t, err)
+
+	// the self signed certificate is not trusted without a pin
+	_, err = Dial(context.Background(), address, &tls.Config{})
+	assert.Error(t, err)
+
+	_, err = Dial(context.Background(), (&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}).String(), PinnedTLSConfig())
+	assert.Error(t, err)
+}
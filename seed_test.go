@@ -1,6 +1,9 @@
 package bitmarklib
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestSeed(t *testing.T) {
 	seed, _ := NewSeed(SeedVersion1, Livenet)
@@ -29,3 +32,47 @@ func TestInvalidBase58Seed(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func FuzzSeedFromBase58(f *testing.F) {
+	f.Add("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	f.Add("5XEECqbX3HpUum7DiRNTRuqWkg8NrFvFDM8GLpckebep6cDgM5eHqzd")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		seed, err := SeedFromBase58(s)
+		if err != nil {
+			return
+		}
+		if len(seed.core) != seedCoreLength {
+			t.Errorf("invalid core length: %d", len(seed.core))
+		}
+	})
+}
+
+func FuzzSeedRoundTrip(f *testing.F) {
+	f.Add(make([]byte, seedCoreLength), false)
+	f.Add(bytes.Repeat([]byte{0xff}, seedCoreLength), true)
+
+	f.Fuzz(func(t *testing.T, core []byte, test bool) {
+		if len(core) != seedCoreLength {
+			t.Skip()
+		}
+
+		network := Livenet
+		if test {
+			network = Testnet
+		}
+		s1 := &Seed{SeedVersion1, network, core}
+
+		s2, err := SeedFromBase58(s1.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s2.network != network || !bytes.Equal(s2.core, core) {
+			t.Error("seed mismatch after round trip")
+		}
+		if s2.String() != s1.String() {
+			t.Error("seed string mismatch after round trip")
+		}
+	})
+}
@@ -0,0 +1,443 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// AssetFileChunkSize is the size of plaintext sealed in each chunk
+	// of a streamed asset file
+	AssetFileChunkSize = 64 * 1024
+
+	streamVersion1 = 0x01
+
+	// the nonce of a chunk is: prefix || counter (4 bytes) || last flag (1 byte)
+	streamCounterSize = 4
+	streamFlagSize    = 1
+)
+
+var (
+	// streams of the second version record how they are signed. Their
+	// header starts with a magic instead of a version byte, so that it
+	// is not taken for the first byte of headerless ciphertext.
+	streamMagic2 = []byte{'B', 'M', 'S', '2'}
+)
+
+var (
+	ErrStreamVersion   = errors.New("unsupported stream version")
+	ErrStreamTruncated = errors.New("encrypted stream is truncated")
+	ErrStreamTooLong   = errors.New("encrypted stream is too long")
+	ErrStreamSignMode  = errors.New("asset file is signed with another sign mode")
+)
+
+// newStreamAEAD returns the AEAD used to seal the chunks of a streamed
+// asset file with the session key
+func newStreamAEAD(key SessionKey) (cipher.AEAD, error) {
+	switch k := key.(type) {
+	case *ChaCha20SessionKey:
+		return chacha20poly1305.NewX(k.key)
+	case *AES256GCMSessionKey:
+		return k.aead()
+	default:
+		return nil, errors.New("unsupported session key for streaming")
+	}
+}
+
+type streamCipher struct {
+	aead    cipher.AEAD
+	ad      []byte
+	nonce   []byte
+	counter uint32
+}
+
+// newStreamCipher returns the cipher of a stream. Every chunk is
+// authenticated along with the additional data and the stream header.
+func newStreamCipher(aead cipher.AEAD, header, additionalData []byte) *streamCipher {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(header)-streamPrefixSize(aead):])
+
+	ad := make([]byte, 0, len(additionalData)+len(header))
+	ad = append(ad, additionalData...)
+	ad = append(ad, header...)
+
+	return &streamCipher{
+		aead:  aead,
+		ad:    ad,
+		nonce: nonce,
+	}
+}
+
+func (s *streamCipher) nextNonce(last bool) ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, ErrStreamTooLong
+	}
+
+	prefixSize := len(s.nonce) - streamCounterSize - streamFlagSize
+	binary.BigEndian.PutUint32(s.nonce[prefixSize:], s.counter)
+	s.nonce[len(s.nonce)-1] = 0x00
+	if last {
+		s.nonce[len(s.nonce)-1] = 0x01
+	}
+	s.counter++
+
+	return s.nonce, nil
+}
+
+func (s *streamCipher) seal(dst, chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(dst, nonce, chunk, s.ad), nil
+}
+
+func (s *streamCipher) open(dst, chunk []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Open(dst, nonce, chunk, s.ad)
+}
+
+func streamPrefixSize(aead cipher.AEAD) int {
+	return aead.NonceSize() - streamCounterSize - streamFlagSize
+}
+
+// streamSignatures returns how many signatures end a stream signed
+// with mode
+func streamSignatures(mode AssetFileSignMode) int {
+	if mode == SignPlaintextAndCiphertext {
+		return 2
+	}
+	return 1
+}
+
+// EncryptAssetFileStream encrypts an asset file from src to dst in
+// chunks, so that the file never has to be held in memory. The output
+// consists of:
+// version of the stream (1 byte)
+// nonce prefix
+// sealed chunks of AssetFileChunkSize bytes, the last one flagged
+// signature of the SHA3-256 digest of the asset file in plaintext
+func EncryptAssetFileStream(dst io.Writer, src io.Reader, key SessionKey, authPvtkey []byte) error {
+	return EncryptAssetFileStreamWithMode(dst, src, key, authPvtkey, SignPlaintext)
+}
+
+// EncryptAssetFileStreamWithMode is EncryptAssetFileStream signed
+// according to mode. Unless mode is SignPlaintext, the stream is of the
+// second version, whose header is the magic "BMS2" (4 bytes) and the
+// mode (1 byte) instead of the version, and the last signature is over
+// the SHA3-256 digest of everything before it, which
+// VerifyAssetFileStream checks.
+func EncryptAssetFileStreamWithMode(dst io.Writer, src io.Reader, key SessionKey, authPvtkey []byte, mode AssetFileSignMode) error {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	return encryptStream(dst, src, aead, AssetFileChunkSize, authPvtkey, nil, mode)
+}
+
+// encryptStream encrypts src to dst. The additional data is covered by
+// both the chunks and the signatures, but is not written to dst.
+func encryptStream(dst io.Writer, src io.Reader, aead cipher.AEAD, chunkSize int, authPvtkey []byte, additionalData []byte, mode AssetFileSignMode) error {
+	if len(authPvtkey) != ed25519.PrivateKeySize {
+		return errors.New("invalid auth private key size")
+	}
+
+	var header []byte
+	switch mode {
+	case SignPlaintext:
+		header = []byte{streamVersion1}
+	case SignCiphertext, SignPlaintextAndCiphertext:
+		header = append(append([]byte{}, streamMagic2...), byte(mode))
+	default:
+		return errors.New("unsupported sign mode")
+	}
+	prefix := make([]byte, streamPrefixSize(aead))
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return err
+	}
+	header = append(header, prefix...)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	s := newStreamCipher(aead, header, additionalData)
+	h := sha3.New256()
+	h.Write(additionalData)
+	c := sha3.New256()
+	c.Write(additionalData)
+	c.Write(header)
+	chunk := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+aead.Overhead())
+
+	for {
+		n, err := io.ReadFull(src, chunk)
+		last := false
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			last = true
+		default:
+			return err
+		}
+
+		h.Write(chunk[:n])
+		sealed, err = s.seal(sealed[:0], chunk[:n], last)
+		if err != nil {
+			return err
+		}
+		c.Write(sealed)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			break
+		}
+	}
+
+	var signatures []byte
+	if mode != SignCiphertext {
+		signature := ed25519.Sign(authPvtkey, h.Sum(nil))
+		c.Write(signature)
+		signatures = append(signatures, signature...)
+	}
+	if mode != SignPlaintext {
+		signatures = append(signatures, ed25519.Sign(authPvtkey, c.Sum(nil))...)
+	}
+	_, err := dst.Write(signatures)
+	return err
+}
+
+// DecryptAssetFileStream decrypts an asset file encrypted by
+// EncryptAssetFileStream or EncryptAssetFileStreamWithMode from src to
+// dst, and checks every signature of it. Plaintext is written to dst as
+// each chunk is authenticated, but the signatures can only be checked
+// at the end of the stream, so the output must be discarded if an
+// error is returned.
+func DecryptAssetFileStream(dst io.Writer, src io.Reader, key SessionKey, authPubkey []byte) error {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	return decryptStream(dst, src, aead, AssetFileChunkSize, authPubkey, nil)
+}
+
+func decryptStream(dst io.Writer, src io.Reader, aead cipher.AEAD, chunkSize int, authPubkey []byte, additionalData []byte) error {
+	if len(authPubkey) != ed25519.PublicKeySize {
+		return errors.New("invalid auth public key size")
+	}
+
+	header, mode, err := readStreamHeader(src)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, streamPrefixSize(aead))
+	if _, err := io.ReadFull(src, prefix); err != nil {
+		return ErrStreamTruncated
+	}
+	header = append(header, prefix...)
+
+	s := newStreamCipher(aead, header, additionalData)
+	h := sha3.New256()
+	h.Write(additionalData)
+	c := sha3.New256()
+	c.Write(additionalData)
+	c.Write(header)
+	sealedSize := chunkSize + aead.Overhead()
+	trailerSize := streamSignatures(mode) * ed25519.SignatureSize
+
+	// keep the signatures worth of lookahead, so the last chunk can be
+	// told apart from the signatures that follow it
+	buf := make([]byte, sealedSize+trailerSize)
+	plaintext := make([]byte, 0, chunkSize)
+	n := 0
+
+	for {
+		m, err := io.ReadFull(src, buf[n:])
+		n += m
+		switch err {
+		case nil:
+			plaintext, err = s.open(plaintext[:0], buf[:sealedSize], false)
+			if err != nil {
+				return err
+			}
+			c.Write(buf[:sealedSize])
+			h.Write(plaintext)
+			if _, err := dst.Write(plaintext); err != nil {
+				return err
+			}
+
+			n = copy(buf, buf[sealedSize:n])
+			continue
+		case io.EOF, io.ErrUnexpectedEOF:
+		default:
+			return err
+		}
+
+		if n < aead.Overhead()+trailerSize {
+			return ErrStreamTruncated
+		}
+
+		plaintext, err = s.open(plaintext[:0], buf[:n-trailerSize], true)
+		if err != nil {
+			return err
+		}
+		c.Write(buf[:n-trailerSize])
+		h.Write(plaintext)
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+
+		signatures := buf[n-trailerSize : n]
+		if mode != SignCiphertext {
+			if !ed25519.Verify(authPubkey, h.Sum(nil), signatures[:ed25519.SignatureSize]) {
+				return errors.New("invalid signature")
+			}
+			c.Write(signatures[:ed25519.SignatureSize])
+		}
+		if mode != SignPlaintext {
+			if !ed25519.Verify(authPubkey, c.Sum(nil), signatures[len(signatures)-ed25519.SignatureSize:]) {
+				return errors.New("invalid signature")
+			}
+		}
+		return nil
+	}
+}
+
+// readStreamHeader reads the version of a stream, and its sign mode if
+// the version has one, and returns them as read
+func readStreamHeader(src io.Reader) ([]byte, AssetFileSignMode, error) {
+	header := make([]byte, 1, len(streamMagic2)+1)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, 0, ErrStreamTruncated
+	}
+
+	switch header[0] {
+	case streamVersion1:
+		return header, SignPlaintext, nil
+	case streamMagic2[0]:
+		header = header[:len(streamMagic2)+1]
+		if _, err := io.ReadFull(src, header[1:]); err != nil {
+			return nil, 0, ErrStreamTruncated
+		}
+		mode := streamSignMode(header)
+		if mode == SignPlaintext {
+			return nil, 0, ErrStreamVersion
+		}
+		return header, mode, nil
+	default:
+		return nil, 0, ErrStreamVersion
+	}
+}
+
+// VerifyAssetFileStream checks the signature over the ciphertext of an
+// asset file encrypted by EncryptAssetFileStreamWithMode, without the
+// session key. A stream signed with SignPlaintext can not be checked
+// this way, and ErrStreamSignMode is returned.
+func VerifyAssetFileStream(src io.Reader, authPubkey []byte) error {
+	return verifyStream(src, authPubkey, nil)
+}
+
+func verifyStream(src io.Reader, authPubkey []byte, additionalData []byte) error {
+	if len(authPubkey) != ed25519.PublicKeySize {
+		return errors.New("invalid auth public key size")
+	}
+
+	header, mode, err := readStreamHeader(src)
+	if err != nil {
+		return err
+	}
+	if mode == SignPlaintext {
+		return ErrStreamSignMode
+	}
+
+	// the nonce prefix is hashed with the chunks, so its size, which
+	// depends on the algorithm, does not need to be known
+	c := sha3.New256()
+	c.Write(additionalData)
+	c.Write(header)
+	buf := make([]byte, AssetFileChunkSize+ed25519.SignatureSize)
+	n := 0
+	for {
+		m, err := io.ReadFull(src, buf[n:])
+		n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		c.Write(buf[:n-ed25519.SignatureSize])
+		n = copy(buf, buf[n-ed25519.SignatureSize:n])
+	}
+
+	if n < ed25519.SignatureSize {
+		return ErrStreamTruncated
+	}
+	c.Write(buf[:n-ed25519.SignatureSize])
+	if !ed25519.Verify(authPubkey, c.Sum(nil), buf[n-ed25519.SignatureSize:n]) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// streamProbeSize is the size of the start of a stream which
+// probeStream needs to open its first chunk
+func streamProbeSize(aead cipher.AEAD, chunkSize int) int {
+	return len(streamMagic2) + 1 + streamPrefixSize(aead) + chunkSize + aead.Overhead() + 2*ed25519.SignatureSize
+}
+
+// probeStream reports whether start, which holds the first
+// streamProbeSize bytes of some content or all of it if shorter, is the
+// start of a stream sealed with aead. The first chunk is opened, as the
+// header of a stream may also begin content made by EncryptAssetFile.
+func probeStream(start []byte, aead cipher.AEAD, chunkSize int) bool {
+	header, mode, err := readStreamHeader(bytes.NewReader(start))
+	if err != nil {
+		return false
+	}
+	headerSize := len(header) + streamPrefixSize(aead)
+	if len(start) < headerSize {
+		return false
+	}
+
+	s := newStreamCipher(aead, start[:headerSize], nil)
+	rest := start[headerSize:]
+	sealedSize := chunkSize + aead.Overhead()
+	trailerSize := streamSignatures(mode) * ed25519.SignatureSize
+	switch {
+	case len(rest) >= sealedSize+trailerSize:
+		_, err = s.open(nil, rest[:sealedSize], false)
+	case len(rest) >= aead.Overhead()+trailerSize:
+		_, err = s.open(nil, rest[:len(rest)-trailerSize], true)
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// streamSignMode returns the sign mode recorded in the header of a
+// stream, which is SignPlaintext for any content without one
+func streamSignMode(header []byte) AssetFileSignMode {
+	if len(header) <= len(streamMagic2) || !bytes.HasPrefix(header, streamMagic2) {
+		return SignPlaintext
+	}
+	switch mode := AssetFileSignMode(header[len(streamMagic2)]); mode {
+	case SignCiphertext, SignPlaintextAndCiphertext:
+		return mode
+	default:
+		return SignPlaintext
+	}
+}
@@ -0,0 +1,112 @@
+package bitmarklib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetFileStream(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	for _, size := range []int{0, 1, AssetFileChunkSize - 1, AssetFileChunkSize, AssetFileChunkSize + 1, 3 * AssetFileChunkSize} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		var encrypted bytes.Buffer
+		err := EncryptAssetFileStream(&encrypted, bytes.NewReader(content), sessKey, authKey.PrivateKeyBytes())
+		assert.NoError(t, err)
+
+		var decrypted bytes.Buffer
+		err = DecryptAssetFileStream(&decrypted, bytes.NewReader(encrypted.Bytes()), sessKey, authKey.PublicKeyBytes())
+		assert.NoError(t, err, "size: %d", size)
+		assert.True(t, bytes.Equal(content, decrypted.Bytes()), "size: %d", size)
+	}
+}
+
+func TestAssetFileStreamTampered(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	sessKey, err := NewChaCha20SessionKey()
+	assert.NoError(t, err)
+
+	content := make([]byte, 2*AssetFileChunkSize+100)
+	rand.Read(content)
+
+	var b bytes.Buffer
+	err = EncryptAssetFileStream(&b, bytes.NewReader(content), sessKey, authKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+	encrypted := b.Bytes()
+
+	sealedSize := AssetFileChunkSize + 16
+	headerSize := len(encrypted) - 2*sealedSize - (100 + 16) - 64
+
+	decrypt := func(encrypted []byte) error {
+		return DecryptAssetFileStream(&bytes.Buffer{}, bytes.NewReader(encrypted), sessKey, authKey.PublicKeyBytes())
+	}
+
+	// flip a bit of the second chunk
+	tampered := append([]byte{}, encrypted...)
+	tampered[headerSize+sealedSize] ^= 0x01
+	assert.Error(t, decrypt(tampered))
+
+	// drop the last chunk but keep the signature
+	truncated := append([]byte{}, encrypted[:headerSize+2*sealedSize]...)
+	truncated = append(truncated, encrypted[len(encrypted)-64:]...)
+	assert.Error(t, decrypt(truncated))
+
+	// swap the first two chunks
+	swapped := append([]byte{}, encrypted[:headerSize]...)
+	swapped = append(swapped, encrypted[headerSize+sealedSize:headerSize+2*sealedSize]...)
+	swapped = append(swapped, encrypted[headerSize:headerSize+sealedSize]...)
+	swapped = append(swapped, encrypted[headerSize+2*sealedSize:]...)
+	assert.Error(t, decrypt(swapped))
+
+	// signature by someone else
+	other, _ := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	err = DecryptAssetFileStream(&bytes.Buffer{}, bytes.NewReader(encrypted), sessKey, other.PublicKeyBytes())
+	assert.Error(t, err)
+
+	assert.Equal(t, ErrStreamTruncated, decrypt(encrypted[:10]))
+}
+
+func TestAssetFileStreamSignMode(t *testing.T) {
+	authKey, _ := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	other, _ := mustSeedKeys("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	sessKey, err := NewAES256GCMSessionKey()
+	assert.NoError(t, err)
+
+	for _, size := range []int{0, AssetFileChunkSize, 2*AssetFileChunkSize + 100} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		for _, mode := range []AssetFileSignMode{SignCiphertext, SignPlaintextAndCiphertext} {
+			var b bytes.Buffer
+			err := EncryptAssetFileStreamWithMode(&b, bytes.NewReader(content), sessKey, authKey.PrivateKeyBytes(), mode)
+			assert.NoError(t, err)
+			encrypted := b.Bytes()
+			assert.Equal(t, mode, AssetFileSignModeOf(encrypted))
+
+			var decrypted bytes.Buffer
+			err = DecryptAssetFileStream(&decrypted, bytes.NewReader(encrypted), sessKey, authKey.PublicKeyBytes())
+			assert.NoError(t, err, "size: %d, mode: %d", size, mode)
+			assert.True(t, bytes.Equal(content, decrypted.Bytes()), "size: %d, mode: %d", size, mode)
+
+			assert.NoError(t, VerifyAssetFileStream(bytes.NewReader(encrypted), authKey.PublicKeyBytes()))
+			assert.Error(t, VerifyAssetFileStream(bytes.NewReader(encrypted), other.PublicKeyBytes()))
+
+			tampered := append([]byte{}, encrypted...)
+			tampered[len(tampered)/2] ^= 0x01
+			assert.Error(t, VerifyAssetFileStream(bytes.NewReader(tampered), authKey.PublicKeyBytes()))
+			assert.Error(t, DecryptAssetFileStream(&bytes.Buffer{}, bytes.NewReader(tampered), sessKey, authKey.PublicKeyBytes()))
+		}
+	}
+
+	var b bytes.Buffer
+	assert.NoError(t, EncryptAssetFileStream(&b, bytes.NewReader([]byte("Hello, world!")), sessKey, authKey.PrivateKeyBytes()))
+	assert.Equal(t, ErrStreamSignMode, VerifyAssetFileStream(bytes.NewReader(b.Bytes()), authKey.PublicKeyBytes()))
+	assert.Error(t, EncryptAssetFileStreamWithMode(&bytes.Buffer{}, bytes.NewReader(nil), sessKey, authKey.PrivateKeyBytes(), 3))
+}
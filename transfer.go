@@ -3,11 +3,13 @@ package bitmarklib
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/bitmark-inc/bitmarkd/account"
 	"github.com/bitmark-inc/bitmarkd/merkle"
 	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/bitmarkd/util"
 	"golang.org/x/crypto/ed25519"
 )
 
@@ -40,20 +42,20 @@ func NewTransfer(txId, newOwner string) (*Transfer, error) {
 // Sign will sign a transfer with an owner private key. This action
 // won't check whether a transfer belongs to an owner.
 func (t *Transfer) Sign(kp *KeyPair) error {
-	packed, _ := t.Pack(t.Owner)
-	if nil == packed {
+	packed, err := t.packUnsigned()
+	if err != nil {
 		return fmt.Errorf("fail to pack transfer")
 	}
 
 	ownerAccount := kp.Account()
 	t.Signature = ed25519.Sign(kp.PrivateKeyBytes(), packed)
-	_, err := t.Pack(ownerAccount)
+	_, err = t.Pack(ownerAccount)
 	return err
 }
 
 func (t *Transfer) ClaimedBy(key AuthKey) error {
-	packed, err := t.Pack(t.Owner)
-	if packed == nil {
+	packed, err := t.packUnsigned()
+	if err != nil {
 		return err
 	}
 
@@ -61,6 +63,82 @@ func (t *Transfer) ClaimedBy(key AuthKey) error {
 	return nil
 }
 
+// TxId returns the id of a signed transfer. The signature is not
+// checked, as that needs the account of the previous owner.
+func (t *Transfer) TxId() (string, error) {
+	packed, err := t.packUnsigned()
+	if err != nil {
+		return "", err
+	}
+	if len(t.Signature) == 0 {
+		return "", errors.New("transfer is not signed")
+	}
+	packed = appendPackedBytes(packed, t.Signature)
+	return txIdText(transactionrecord.Packed(packed).MakeLink())
+}
+
+// packUnsigned returns the transfer packed as bitmarkd packs it, without
+// the signature. Pack can not be used for this, as it needs the account
+// of the signer, and refuses the zero account that a burn transfers to.
+// The escrow is checked as Pack checks it, on the network of the owner,
+// which is the network of the signer of a valid transfer.
+func (t *Transfer) packUnsigned() ([]byte, error) {
+	if t.BitmarkTransferUnratified == nil || t.Owner == nil {
+		return nil, errors.New("missing transfer owner")
+	}
+
+	packed := util.ToVarint64(uint64(transactionrecord.BitmarkTransferUnratifiedTag))
+	packed = appendPackedBytes(packed, t.Link[:])
+	if t.Escrow == nil {
+		packed = append(packed, 0)
+	} else {
+		if err := t.Escrow.Currency.ValidateAddress(t.Escrow.Address, t.Owner.IsTesting()); err != nil {
+			return nil, err
+		}
+		packed = append(packed, 1)
+		packed = append(packed, util.ToVarint64(t.Escrow.Currency.Uint64())...)
+		packed = appendPackedBytes(packed, []byte(t.Escrow.Address))
+		packed = append(packed, util.ToVarint64(t.Escrow.Amount)...)
+	}
+	return appendPackedBytes(packed, t.Owner.Bytes()), nil
+}
+
+// appendPackedBytes appends a field prefixed by its length
+func appendPackedBytes(packed, data []byte) []byte {
+	packed = append(packed, util.ToVarint64(uint64(len(data)))...)
+	return append(packed, data...)
+}
+
+// txIdText returns the form of a txid used in JSON and by NewTransfer
+func txIdText(link merkle.Digest) (string, error) {
+	b, err := link.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// TransferWithAccess creates a transfer of the bitmark to the new owner
+// signed by the owner, along with the SessionData which hands the
+// session key of the bitmark's asset file over to the new owner
+func TransferWithAccess(txId, newOwner string, old *SessionData, senderEncryptionPubkey *[32]byte, senderAuthPubkey []byte, ownerKeys *AccountKeys, newOwnerEncryptionPubkey *[32]byte) (*Transfer, *SessionData, error) {
+	t, err := NewTransfer(txId, newOwner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := RewrapSessionData(old, senderEncryptionPubkey, senderAuthPubkey, ownerKeys, newOwnerEncryptionPubkey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := t.ClaimedBy(ownerKeys.AuthKey); err != nil {
+		return nil, nil, err
+	}
+
+	return t, data, nil
+}
+
 // Return the base64 string of the JSON object. Return empty if there is
 // something wrong.
 func (t Transfer) String() string {
@@ -3,6 +3,9 @@ package bitmarklib
 import (
 	"testing"
 
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/bitmark-inc/bitmarkd/currency"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,3 +25,89 @@ func TestTransferSign(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, transfer.Signature)
 }
+
+func TestTransferBurn(t *testing.T) {
+	seed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	owner := mustAccountKeys(seed.String())
+	zero := &account.Account{AccountInterface: &account.ED25519Account{Test: true, PublicKey: make([]byte, 32)}}
+
+	// a bitmark is burnt by a transfer to the zero account
+	transfer, err := NewTransfer("6776599a5fd4f2ade1ca87ee5fffd0295bb69b1969ffab1ec042a5f71ef74209", zero.String())
+	assert.NoError(t, err)
+	assert.NoError(t, transfer.ClaimedBy(owner.AuthKey))
+
+	packed, err := transfer.Pack(owner.AuthKey.PublicKey())
+	assert.NoError(t, err)
+	txId, err := transfer.TxId()
+	assert.NoError(t, err)
+	assert.Equal(t, linkText(packed.MakeLink()), txId)
+
+	unsigned, _ := NewTransfer("6776599a5fd4f2ade1ca87ee5fffd0295bb69b1969ffab1ec042a5f71ef74209", zero.String())
+	_, err = unsigned.TxId()
+	assert.Error(t, err)
+}
+
+func TestTransferEscrow(t *testing.T) {
+	seed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	owner := mustAccountKeys(seed.String())
+
+	transfer, err := NewTransfer("6776599a5fd4f2ade1ca87ee5fffd0295bb69b1969ffab1ec042a5f71ef74209", owner.AuthKey.AccountNumber())
+	assert.NoError(t, err)
+	transfer.Escrow = &transactionrecord.Payment{
+		Currency: currency.Bitcoin,
+		Address:  "mnnemVbQECtikaGZPYux4dGHH3YZyCg4sq",
+		Amount:   250000,
+	}
+	assert.NoError(t, transfer.ClaimedBy(owner.AuthKey))
+	packed, err := transfer.Pack(owner.AuthKey.PublicKey())
+	assert.NoError(t, err)
+	txId, err := transfer.TxId()
+	assert.NoError(t, err)
+	assert.Equal(t, linkText(packed.MakeLink()), txId)
+
+	// an escrow that bitmarkd refuses is not signed
+	for _, address := range []string{"1invalid", "1HB5XMLmzFVj8ALj6mfBsbifRoD4miY36v"} {
+		transfer.Escrow.Address = address
+		transfer.Signature = []byte{}
+		assert.Error(t, transfer.ClaimedBy(owner.AuthKey), address)
+		assert.Empty(t, transfer.Signature)
+
+		transfer.Signature = []byte{0x01}
+		_, err = transfer.TxId()
+		assert.Error(t, err, address)
+	}
+}
+
+func TestTransferWithAccess(t *testing.T) {
+	issuerSeed, _ := SeedFromBase58("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+	issuerKeys, err := NewAccountKeys(issuerSeed)
+	assert.NoError(t, err)
+	ownerSeed, _ := SeedFromBase58("5XEECs4hZZUqxGJHVedGbkxDkKTT6qsSY1tZXSZ2FUAVS8dMoMxFvzR")
+	ownerKeys, err := NewAccountKeys(ownerSeed)
+	assert.NoError(t, err)
+	newSeed, _ := NewSeed(SeedVersion1, Testnet)
+	newOwnerKeys, err := NewAccountKeys(newSeed)
+	assert.NoError(t, err)
+
+	var issuerEncrPvtkey, issuerEncrPubkey, ownerEncrPubkey, newOwnerEncrPubkey, newOwnerEncrPvtkey [32]byte
+	copy(issuerEncrPvtkey[:], issuerKeys.EncrKey.PrivateKeyBytes())
+	copy(issuerEncrPubkey[:], issuerKeys.EncrKey.PublicKeyBytes())
+	copy(ownerEncrPubkey[:], ownerKeys.EncrKey.PublicKeyBytes())
+	copy(newOwnerEncrPubkey[:], newOwnerKeys.EncrKey.PublicKeyBytes())
+	copy(newOwnerEncrPvtkey[:], newOwnerKeys.EncrKey.PrivateKeyBytes())
+
+	sessKey, _ := NewChaCha20SessionKey()
+	old, err := CreateSessionData(sessKey, &ownerEncrPubkey, &issuerEncrPvtkey, issuerKeys.AuthKey.PrivateKeyBytes())
+	assert.NoError(t, err)
+
+	transfer, data, err := TransferWithAccess("6776599a5fd4f2ade1ca87ee5fffd0295bb69b1969ffab1ec042a5f71ef74209", newOwnerKeys.AuthKey.AccountNumber(),
+		old, &issuerEncrPubkey, issuerKeys.AuthKey.PublicKeyBytes(), ownerKeys, &newOwnerEncrPubkey)
+	assert.NoError(t, err)
+
+	_, err = transfer.Pack(ownerKeys.AuthKey.PublicKey())
+	assert.NoError(t, err)
+
+	key, err := SessionKeyFromSessionData(data, &ownerEncrPubkey, &newOwnerEncrPvtkey, ownerKeys.AuthKey.PublicKeyBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, sessKey.Bytes(), key.Bytes())
+}
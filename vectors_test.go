@@ -0,0 +1,260 @@
+package bitmarklib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/stretchr/testify/assert"
+)
+
+// testVectors mirrors testdata/vectors.json, which is shared with the
+// clients in other languages. All binary values are hex encoded.
+type testVectors struct {
+	Version int `json:"version"`
+	Seeds   []struct {
+		Seed           string `json:"seed"`
+		Network        string `json:"network"`
+		Core           string `json:"core"`
+		AuthPrivateKey string `json:"auth_private_key"`
+		AuthPublicKey  string `json:"auth_public_key"`
+		AccountNumber  string `json:"account_number"`
+		EncrPrivateKey string `json:"encr_private_key"`
+		EncrPublicKey  string `json:"encr_public_key"`
+	} `json:"seeds"`
+	KIF []struct {
+		Seed          string `json:"seed"`
+		Test          bool   `json:"test"`
+		KIF           string `json:"kif"`
+		PrivateKey    string `json:"private_key"`
+		AccountNumber string `json:"account_number"`
+	} `json:"kif"`
+	Assets []struct {
+		Seed        string `json:"seed"`
+		Name        string `json:"name"`
+		Fingerprint string `json:"fingerprint"`
+		Metadata    string `json:"metadata"`
+		AssetId     string `json:"asset_id"`
+		Packed      string `json:"packed"`
+		Signature   string `json:"signature"`
+	} `json:"assets"`
+	Issues []struct {
+		Seed      string `json:"seed"`
+		AssetId   string `json:"asset_id"`
+		Nonce     uint64 `json:"nonce"`
+		Packed    string `json:"packed"`
+		TxId      string `json:"txid"`
+		Signature string `json:"signature"`
+	} `json:"issues"`
+	Transfers []struct {
+		Seed      string `json:"seed"`
+		Link      string `json:"link"`
+		Owner     string `json:"owner"`
+		Packed    string `json:"packed"`
+		TxId      string `json:"txid"`
+		Signature string `json:"signature"`
+	} `json:"transfers"`
+	AssetFiles []struct {
+		Seed       string `json:"seed"`
+		Algorithm  int    `json:"algorithm"`
+		SessionKey string `json:"session_key"`
+		Plaintext  string `json:"plaintext"`
+		Encrypted  string `json:"encrypted"`
+	} `json:"asset_files"`
+	Sessions []struct {
+		SenderSeed    string      `json:"sender_seed"`
+		RecipientSeed string      `json:"recipient_seed"`
+		Algorithm     int         `json:"algorithm"`
+		SessionKey    string      `json:"session_key"`
+		SessionData   SessionData `json:"session_data"`
+	} `json:"sessions"`
+}
+
+func loadTestVectors(t *testing.T) *testVectors {
+	b, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v testVectors
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 1 {
+		t.Fatalf("unsupported test vector version: %d", v.Version)
+	}
+	return &v
+}
+
+func TestVectorSeeds(t *testing.T) {
+	for _, v := range loadTestVectors(t).Seeds {
+		seed, err := SeedFromBase58(v.Seed)
+		if !assert.NoError(t, err, v.Seed) {
+			continue
+		}
+		assert.Equal(t, v.Seed, seed.String())
+		assert.Equal(t, v.Core, hex.EncodeToString(seed.core))
+		assert.Equal(t, v.Network == "testnet", seed.network == Testnet)
+
+		authKey, err := NewAuthKey(seed)
+		assert.NoError(t, err)
+		assert.Equal(t, v.AuthPrivateKey, hex.EncodeToString(authKey.PrivateKeyBytes()))
+		assert.Equal(t, v.AuthPublicKey, hex.EncodeToString(authKey.PublicKeyBytes()))
+		assert.Equal(t, v.AccountNumber, authKey.AccountNumber())
+
+		encrKey, err := NewEncrKey(seed)
+		assert.NoError(t, err)
+		assert.Equal(t, v.EncrPrivateKey, hex.EncodeToString(encrKey.PrivateKeyBytes()))
+		assert.Equal(t, v.EncrPublicKey, hex.EncodeToString(encrKey.PublicKeyBytes()))
+	}
+}
+
+func TestVectorKIF(t *testing.T) {
+	for _, v := range loadTestVectors(t).KIF {
+		seed, _ := hex.DecodeString(v.Seed)
+		kp, err := NewKeyPairFromSeed(seed, v.Test, ED25519)
+		assert.NoError(t, err)
+
+		kif, err := kp.KIF()
+		assert.NoError(t, err)
+		assert.Equal(t, v.KIF, kif)
+		assert.Equal(t, v.PrivateKey, kp.String())
+		assert.Equal(t, v.AccountNumber, kp.Account().String())
+
+		kp, err = NewKeyPairFromKIF(v.KIF)
+		assert.NoError(t, err)
+		assert.Equal(t, v.Seed, hex.EncodeToString(kp.SeedBytes()))
+		assert.Equal(t, v.Test, kp.PrivateKey.IsTesting())
+	}
+}
+
+// linkText returns a txid in the byte order used by the JSON records
+func linkText(link merkle.Digest) string {
+	b, _ := link.MarshalText()
+	return string(b)
+}
+
+func vectorAuthKey(t *testing.T, s string) AuthKey {
+	seed, err := SeedFromBase58(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authKey, err := NewAuthKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authKey
+}
+
+func TestVectorAssets(t *testing.T) {
+	for _, v := range loadTestVectors(t).Assets {
+		authKey := vectorAuthKey(t, v.Seed)
+
+		asset := NewAsset(v.Name, v.Fingerprint)
+		asset.Metadata = v.Metadata
+		assert.NoError(t, asset.ClaimedBy(authKey))
+		assert.Equal(t, v.Signature, hex.EncodeToString(asset.Signature))
+
+		packed, err := asset.Pack(authKey.PublicKey())
+		assert.NoError(t, err)
+		assert.Equal(t, v.Packed, hex.EncodeToString(packed))
+		assert.Equal(t, v.AssetId, asset.AssetId().String())
+	}
+}
+
+func TestVectorIssues(t *testing.T) {
+	for _, v := range loadTestVectors(t).Issues {
+		authKey := vectorAuthKey(t, v.Seed)
+
+		var assetId transactionrecord.AssetIdentifier
+		assert.NoError(t, assetId.UnmarshalText([]byte(v.AssetId)))
+
+		// the nonce is fixed, so sign the packed record directly
+		// instead of going through ClaimedBy
+		issue := NewIssue(assetId)
+		issue.Owner = authKey.PublicKey()
+		issue.Nonce = v.Nonce
+		unsigned, _ := issue.Pack(issue.Owner)
+		issue.Signature = authKey.Sign(unsigned)
+		assert.Equal(t, v.Signature, hex.EncodeToString(issue.Signature))
+
+		packed, err := issue.Pack(issue.Owner)
+		assert.NoError(t, err)
+		assert.Equal(t, v.Packed, hex.EncodeToString(packed))
+		assert.Equal(t, v.TxId, linkText(packed.MakeLink()))
+
+		txId, err := issue.TxId()
+		assert.NoError(t, err)
+		assert.Equal(t, v.TxId, txId)
+	}
+}
+
+func TestVectorTransfers(t *testing.T) {
+	for _, v := range loadTestVectors(t).Transfers {
+		authKey := vectorAuthKey(t, v.Seed)
+
+		transfer, err := NewTransfer(v.Link, v.Owner)
+		assert.NoError(t, err)
+		unsigned, _ := transfer.Pack(authKey.PublicKey())
+		transfer.Signature = authKey.Sign(unsigned)
+		assert.Equal(t, v.Signature, hex.EncodeToString(transfer.Signature))
+
+		packed, err := transfer.Pack(authKey.PublicKey())
+		assert.NoError(t, err)
+		assert.Equal(t, v.Packed, hex.EncodeToString(packed))
+		assert.Equal(t, v.TxId, linkText(packed.MakeLink()))
+
+		txId, err := transfer.TxId()
+		assert.NoError(t, err)
+		assert.Equal(t, v.TxId, txId)
+	}
+}
+
+func TestVectorAssetFiles(t *testing.T) {
+	for _, v := range loadTestVectors(t).AssetFiles {
+		authKey := vectorAuthKey(t, v.Seed)
+		sessKey, err := SessionKeyFromHex(v.Algorithm, v.SessionKey)
+		assert.NoError(t, err)
+
+		plaintext, _ := hex.DecodeString(v.Plaintext)
+		encrypted, _ := hex.DecodeString(v.Encrypted)
+		decrypted, err := DecryptAssetFile(encrypted, sessKey, authKey.PublicKeyBytes())
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted)
+
+		// the vectors are in the zero nonce format, which is the only
+		// deterministic one
+		ciphertext, err := sessKey.(*ChaCha20SessionKey).LegacyEncrypt(plaintext)
+		assert.NoError(t, err)
+		assert.Equal(t, v.Encrypted, hex.EncodeToString(append(ciphertext, authKey.Sign(plaintext)...)))
+	}
+}
+
+func TestVectorSessions(t *testing.T) {
+	for _, v := range loadTestVectors(t).Sessions {
+		sender, err := SeedFromBase58(v.SenderSeed)
+		assert.NoError(t, err)
+		recipient, err := SeedFromBase58(v.RecipientSeed)
+		assert.NoError(t, err)
+
+		sAuthKey, _ := NewAuthKey(sender)
+		sEncrKey, _ := NewEncrKey(sender)
+		rEncrKey, _ := NewEncrKey(recipient)
+
+		sessKey, err := SessionKeyFromSessionData(&v.SessionData, toKey32(sEncrKey.PublicKeyBytes()), toKey32(rEncrKey.PrivateKeyBytes()), sAuthKey.PublicKeyBytes())
+		assert.NoError(t, err)
+		assert.Equal(t, v.SessionKey, sessKey.String())
+
+		// the session data of a Chacha20poly1305 key is unversioned
+		alg, _ := sessionKeyAlgorithm(sessKey)
+		assert.Equal(t, v.Algorithm, alg)
+		assert.Equal(t, v.Algorithm != Chacha20poly1305, v.SessionData.Version == SessionDataVersion1)
+
+		// the nonce of an encrypted session key is random, but the
+		// signature over the session key is deterministic
+		assert.Equal(t, hex.EncodeToString(sAuthKey.Sign(sessKey.Bytes())), hex.EncodeToString(v.SessionData.SessionKeySignature))
+	}
+}
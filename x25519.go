@@ -0,0 +1,107 @@
+package bitmarklib
+
+import (
+	"crypto/sha512"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	// p = 2^255 - 19
+	curve25519P, _ = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+
+	// d = -121665/121666, the constant of the twisted Edwards curve
+	edwards25519D, _ = new(big.Int).SetString("37095705934669439343138083508754565189542113879843219016388785533085940283555", 10)
+)
+
+// EncrKey converts the ed25519 key of a keypair to an X25519 key, so
+// that an account without a seed of version 1 can still receive data
+// encrypted to it. The X25519 private key is the clamped scalar hashed
+// from the ed25519 seed, as in the ed25519 signing key itself.
+func (kp KeyPair) EncrKey() (EncrKey, error) {
+	if kp.PrivateKey == nil {
+		return nil, ErrInvalidAlgorithm
+	}
+	edPrivateKey := kp.PrivateKey.PrivateKeyBytes()
+	if len(edPrivateKey) != ed25519.PrivateKeySize {
+		return nil, ErrKeyLength
+	}
+
+	h := sha512.Sum512(edPrivateKey[:ed25519.SeedSize])
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+
+	privateKey := new([32]byte)
+	copy(privateKey[:], h[:32])
+
+	pub, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := new([32]byte)
+	copy(publicKey[:], pub)
+
+	return CURVE25519EncrKey{publicKey, privateKey}, nil
+}
+
+// EncrPublicKeyBytes converts the ed25519 public key to the X25519
+// public key of the keypair's EncrKey
+func (p PublicKey) EncrPublicKeyBytes() ([]byte, error) {
+	return ed25519PublicKeyToX25519(p.Account.PublicKeyBytes())
+}
+
+// ed25519PublicKeyToX25519 maps an Edwards point to the Montgomery
+// u-coordinate by the birational map of RFC 7748, u = (1 + y) / (1 - y)
+func ed25519PublicKeyToX25519(edPublicKey []byte) ([]byte, error) {
+	if len(edPublicKey) != ed25519.PublicKeySize {
+		return nil, ErrKeyLength
+	}
+
+	// the encoding is y in little-endian with the sign of x in the top bit
+	le := make([]byte, len(edPublicKey))
+	copy(le, edPublicKey)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverseBytes(le))
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+
+	// the point is on the curve if x^2 = (y^2 - 1) / (d*y^2 + 1) has a root
+	p := curve25519P
+	y2 := new(big.Int).Mul(y, y)
+	num := new(big.Int).Sub(y2, big.NewInt(1))
+	den := new(big.Int).Mul(edwards25519D, y2)
+	den.Add(den, big.NewInt(1)).Mod(den, p)
+	x2 := num.Mul(num, den.ModInverse(den, p)).Mod(num, p)
+	if x2.Sign() != 0 {
+		exp := new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(1)), 1)
+		if new(big.Int).Exp(x2, exp, p).Cmp(big.NewInt(1)) != 0 {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+	}
+
+	// y = 1 is the identity, which has no Montgomery form
+	oneMinusY := new(big.Int).Sub(big.NewInt(1), y)
+	oneMinusY.Mod(oneMinusY, p)
+	if oneMinusY.Sign() == 0 {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, oneMinusY.ModInverse(oneMinusY, p)).Mod(u, p)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverseBytes(out), nil
+}
+
+func reverseBytes(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,71 @@
+package bitmarklib
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/account"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPairEncrKey(t *testing.T) {
+	// test vector of crypto_sign_ed25519_{pk,sk}_to_curve25519 in libsodium
+	seed, _ := hex.DecodeString("421151a459faeade3d247115f94aedae42318124095afabe4d1451a559faedee")
+	kp, err := NewKeyPairFromSeed(seed, true, ED25519)
+	assert.NoError(t, err)
+
+	encrKey, err := kp.EncrKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "b5076a8474a832daee4dd5b4040983b6623b5f344aca57d4d6ee4baf3f259e6e", hex.EncodeToString(kp.PrivateKey.Account().PublicKeyBytes()))
+	assert.Equal(t, "8052030376d47112be7f73ed7a019293dd12ad910b654455798b4667d73de166", hex.EncodeToString(encrKey.PrivateKeyBytes()))
+	assert.Equal(t, "f1814f0e8ff1043d8a44d25babff3cedcae6c22c3edaa48f857ae70de2baae50", hex.EncodeToString(encrKey.PublicKeyBytes()))
+
+	pub := PublicKey{kp.PrivateKey.Account()}
+	encrPubKey, err := pub.EncrPublicKeyBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, encrKey.PublicKeyBytes(), encrPubKey)
+}
+
+func TestKeyPairEncrKeyRoundTrip(t *testing.T) {
+	_, sender := mustSeedKeys("5XEECsYGDXGWmBnSrExALVTWhzj9mNXxs3y98TgrtkLi6GE4qfoammV")
+
+	for i := 0; i < 20; i++ {
+		kp, err := NewKeyPair(true, ED25519)
+		assert.NoError(t, err)
+
+		// a legacy account only publishes its ed25519 public key
+		pub := PublicKey{kp.PrivateKey.Account()}
+		encrPubKey, err := pub.EncrPublicKeyBytes()
+		assert.NoError(t, err)
+
+		ciphertext, err := sender.Encrypt([]byte("session key"), encrPubKey)
+		assert.NoError(t, err)
+
+		encrKey, err := kp.EncrKey()
+		assert.NoError(t, err)
+		plaintext, err := encrKey.Decrypt(ciphertext, sender.PublicKeyBytes())
+		assert.NoError(t, err)
+		assert.Equal(t, "session key", string(plaintext))
+	}
+}
+
+func TestEncrPublicKeyBytesInvalid(t *testing.T) {
+	newPublicKey := func(key []byte) PublicKey {
+		return PublicKey{&account.Account{AccountInterface: &account.ED25519Account{PublicKey: key}}}
+	}
+
+	// the identity point
+	identity := make([]byte, 32)
+	identity[0] = 0x01
+	_, err := newPublicKey(identity).EncrPublicKeyBytes()
+	assert.Error(t, err)
+
+	// y = 2 is not on the curve
+	notOnCurve := make([]byte, 32)
+	notOnCurve[0] = 0x02
+	_, err = newPublicKey(notOnCurve).EncrPublicKeyBytes()
+	assert.Error(t, err)
+
+	_, err = newPublicKey(make([]byte, 31)).EncrPublicKeyBytes()
+	assert.Equal(t, ErrKeyLength, err)
+}